@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const textTimeFormat = "2006-01-02 15:04:05"
+
+// newFormatter picks the line format written to the storage file. text keeps the historic `time [LEVL] message` layout and appends fields after a tab.
+func newFormatter(format string, color bool) (logrus.Formatter, error) {
+	switch format {
+	case "text", "":
+		return &textFormatter{Color: color}, nil
+	case "json":
+		return &logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano}, nil
+	case "logfmt":
+		return &logrus.TextFormatter{DisableColors: true, FullTimestamp: true, TimestampFormat: time.RFC3339Nano}, nil
+	default:
+		return nil, fmt.Errorf("no such log format %q, possible values: text, json, logfmt", format)
+	}
+}
+
+// parseLevel only accepts the levels ansible writes, fatal and panic would end the process.
+func parseLevel(name string) (logrus.Level, error) {
+	switch name {
+	case "debug":
+		return logrus.DebugLevel, nil
+	case "info":
+		return logrus.InfoLevel, nil
+	case "warn":
+		return logrus.WarnLevel, nil
+	case "error":
+		return logrus.ErrorLevel, nil
+	default:
+		return 0, fmt.Errorf("no such log level %q, possible values: debug, info, warn, error", name)
+	}
+}
+
+// parseFields turns repeated key=value flags and the tag into entry fields.
+func parseFields(pairs []string, tag string) (logrus.Fields, error) {
+	fields := make(logrus.Fields, len(pairs)+1)
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("field %q is not in key=value form", pair)
+		}
+		switch key {
+		case "time", "level", "msg":
+			return nil, fmt.Errorf("field key %q is reserved", key)
+		}
+		fields[key] = value
+	}
+	if tag != "" {
+		fields["tag"] = tag
+	}
+	return fields, nil
+}
+
+type textFormatter struct {
+	Color bool
+}
+
+func (f *textFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	b := &bytes.Buffer{}
+	b.WriteString(entry.Time.Format(textTimeFormat))
+	b.WriteString(" [")
+	b.WriteString(levelLabel(entry.Level, f.Color))
+	b.WriteString("] ")
+	b.WriteString(entry.Message)
+	if len(entry.Data) > 0 {
+		b.WriteByte('\t')
+		keys := make([]string, 0, len(entry.Data))
+		for k := range entry.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(k)
+			b.WriteByte('=')
+			b.WriteString(quoteValue(fmt.Sprint(entry.Data[k])))
+		}
+	}
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+// levelLabel mirrors errorutils: the first four letters of the level, optionally colored.
+func levelLabel(level logrus.Level, color bool) string {
+	label := strings.ToUpper(level.String())[0:4]
+	if !color {
+		return label
+	}
+	return fmt.Sprintf("\x1b[%dm%s\x1b[0m", levelColor(level), label)
+}
+
+func levelColor(level logrus.Level) int {
+	switch level {
+	case logrus.DebugLevel, logrus.TraceLevel:
+		return 37
+	case logrus.WarnLevel:
+		return 33
+	case logrus.ErrorLevel, logrus.FatalLevel, logrus.PanicLevel:
+		return 31
+	default:
+		return 36
+	}
+}
+
+func quoteValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+		return strconv.Quote(v)
+	}
+	return v
+}
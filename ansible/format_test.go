@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v3"
+)
+
+// runAnsible runs the command line against a storage file in a new temporary directory and returns its path.
+// Exit codes come back as errors instead of ending the test binary.
+func runAnsible(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ansible.log")
+	cmd := app
+	cmd.ExitErrHandler = func(context.Context, *cli.Command, error) {}
+	err := cmd.Run(context.Background(), append([]string{"ansible", "--storage", path, "--format", "json"}, args...))
+	return path, err
+}
+
+func TestFormats(t *testing.T) {
+	fields, err := parseFields([]string{"host=db 1", "path=/var"}, "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		format, want string
+	}{
+		{"text", "2024-05-06 07:08:09 [WARN] disk full\thost=\"db 1\" path=/var tag=backup\n"},
+		{"json", `{"host":"db 1","level":"warning","msg":"disk full","path":"/var","tag":"backup","time":"2024-05-06T07:08:09Z"}` + "\n"},
+		{"logfmt", `time="2024-05-06T07:08:09Z" level=warning msg="disk full" host="db 1" path=/var tag=backup` + "\n"},
+	} {
+		formatter, err := newFormatter(tc.format, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		logger := logrus.New()
+		logger.SetOutput(&b)
+		logger.SetFormatter(formatter)
+		logger.WithFields(fields).WithTime(time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)).Warn("disk full")
+		if b.String() != tc.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tc.format, b.String(), tc.want)
+		}
+	}
+}
+
+func TestRejectedFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"--level", "loud"},
+		{"--level", "fatal"},
+		{"--format", "xml"},
+		{"--field", "nokey"},
+		{"--field", "=value"},
+		{"--field", "msg=reserved"},
+	} {
+		if _, err := runAnsible(t, append(args, "a message")...); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
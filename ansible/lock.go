@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pydpll/errorutils"
+	"github.com/urfave/cli/v3"
+)
+
+// lockTimeout bounds a single wait for the storage flock, writes take milliseconds so a longer hold means a stuck writer.
+const lockTimeout = 3 * time.Minute
+
+// errReplaced stops a lock wait because the path now names another file, the waiter has to reopen it.
+var errReplaced = errors.New("storage file replaced")
+
+var unlockCmd = &cli.Command{
+	Name:  "unlock",
+	Usage: "show who holds the lock of the storage file and break it when the holder is gone",
+	Description: "the lock is stale when its holder was a process of this host that no longer runs, or when it was taken more than --older-than ago. " +
+		"Breaking it replaces the storage file with a copy, writers waiting on the old one move to the copy. A live lock is only broken with --force.",
+	Action: unlock,
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "older-than",
+			Usage: "consider a lock taken more than `DURATION` ago stale, 0 only trusts the holder's pid",
+			Value: lockTimeout,
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "break the lock even if its holder looks alive",
+		},
+	},
+}
+
+// lockHolder is written next to the storage file by every process taking the flock.
+type lockHolder struct {
+	Pid   int       `json:"pid"`
+	Host  string    `json:"host"`
+	Since time.Time `json:"since"`
+	Cmd   string    `json:"cmd"`
+}
+
+func holderPath(path string) string {
+	return path + ".lock"
+}
+
+// lockFile polls a non blocking flock so waiting ends with ctx, nothing is left blocked in the kernel. replaced, when given, is checked between attempts.
+func lockFile(ctx context.Context, file *os.File, replaced func() bool) error {
+	wait := time.Millisecond
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return nil
+		}
+		if err != syscall.EWOULDBLOCK && err != syscall.EINTR {
+			return err
+		}
+		if replaced != nil && replaced() {
+			return errReplaced
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		if wait < 16*time.Millisecond {
+			wait *= 2
+		}
+	}
+}
+
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
+
+// lockError names the holder the wait gave up on.
+func lockError(err error, path string) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = errTIMEOUT
+	}
+	msg := path + " is locked"
+	if h, readErr := readHolder(path); readErr == nil {
+		msg += " by " + h.String()
+	}
+	return fmt.Errorf("%w: %s, see ansible unlock", err, msg)
+}
+
+// writeHolder is best effort, a missing record only makes `ansible unlock` less helpful.
+func writeHolder(file *os.File) {
+	host, _ := os.Hostname()
+	record, _ := json.Marshal(lockHolder{Pid: os.Getpid(), Host: host, Since: time.Now(), Cmd: strings.Join(os.Args, " ")})
+	record = append(record, '\n')
+	if _, err := file.WriteAt(record, 0); err == nil {
+		file.Truncate(int64(len(record)))
+	}
+}
+
+func readHolder(path string) (lockHolder, error) {
+	var h lockHolder
+	data, err := os.ReadFile(holderPath(path))
+	if err != nil {
+		return h, err
+	}
+	err = json.Unmarshal(data, &h)
+	return h, err
+}
+
+func (h lockHolder) String() string {
+	return fmt.Sprintf("pid %d on %s since %s (%s ago): %s", h.Pid, h.Host, h.Since.Format(textTimeFormat), time.Since(h.Since).Round(time.Second), h.Cmd)
+}
+
+// stale reports why the lock can be broken, or "" while its holder may still be writing.
+func (h lockHolder) stale(olderThan time.Duration, now time.Time) string {
+	if host, _ := os.Hostname(); h.Host == host && h.Pid > 0 && syscall.Kill(h.Pid, 0) == syscall.ESRCH {
+		return "the holder no longer runs"
+	}
+	if olderThan > 0 && now.Sub(h.Since) > olderThan {
+		return "taken more than " + olderThan.String() + " ago"
+	}
+	return ""
+}
+
+func unlock(ctx context.Context, cmd *cli.Command) error {
+	path := cmd.String("storage")
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	holder, holderErr := readHolder(path)
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == nil {
+		unlockFile(file)
+		fmt.Println(path + " is not locked")
+		if holderErr == nil {
+			fmt.Println("last held by " + holder.String())
+		}
+		return nil
+	}
+	if err != syscall.EWOULDBLOCK {
+		return err
+	}
+	if holderErr != nil {
+		fmt.Println(path + " is locked by an unknown holder")
+	} else {
+		fmt.Println(path + " is locked by " + holder.String())
+	}
+
+	reason := "--force"
+	if !cmd.Bool("force") {
+		if holderErr == nil {
+			reason = holder.stale(cmd.Duration("older-than"), time.Now())
+		} else {
+			reason = ""
+		}
+		if reason == "" {
+			return errorutils.NewReport("the holder may still be writing, use --force to break the lock anyway", "", errorutils.WithExitCode(1))
+		}
+	}
+	if err := breakLock(path); err != nil {
+		return err
+	}
+	fmt.Println("lock broken: " + reason)
+	return nil
+}
+
+// breakLock swaps the storage file for a copy of itself. A flock can only be released by its holder, a new inode leaves it behind.
+func breakLock(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".unlock*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"log/slog"
 	"os"
+	"os/signal"
 	"strings"
 	"syscall"
 	"time"
@@ -54,19 +55,53 @@ var app = cli.Command{
 			Aliases: []string{"l"},
 			Value:   "info",
 		},
+		&cli.StringFlag{
+			Name:    "format",
+			Usage:   "line format of the storage file. possible values: text, json, logfmt",
+			Aliases: []string{"f"},
+			Value:   "text",
+		},
+		&cli.StringSliceFlag{
+			Name:  "field",
+			Usage: "`KEY=VALUE` added to every line, can be repeated",
+		},
+		&cli.StringFlag{
+			Name:  "rotate-size",
+			Usage: "rotate the storage file before it grows past `SIZE` (e.g. 10M, 1G)",
+		},
+		&cli.DurationFlag{
+			Name:  "rotate-every",
+			Usage: "rotate the storage file when its last line was written in an earlier `PERIOD` (e.g. 24h, aligned to UTC)",
+		},
+		&cli.IntFlag{
+			Name:  "rotate-keep",
+			Usage: "number of rotated files kept next to the storage file",
+			Value: 5,
+		},
+		&cli.BoolFlag{
+			Name:  "rotate-compress",
+			Usage: "gzip rotated files",
+		},
+		&cli.StringFlag{
+			Name:  "socket",
+			Usage: "unix socket `PATH` of ansible serve. default: the storage file with a .sock suffix",
+		},
+		&cli.StringSliceFlag{
+			Name:    "sink",
+			Usage:   "`KIND[=TARGET]` receiving every line, can be repeated: file (--storage), stderr, syslog[=SOCKET] (RFC 5424, default /dev/log), journal=PATH (journal export format). default: file",
+			Sources: cli.EnvVars("ANSIBLE_SINK"),
+		},
+		&cli.StringFlag{
+			Name:    "tag",
+			Usage:   "`TAG` added to every line as the tag field",
+			Aliases: []string{"t"},
+		},
 	},
 	Commands: []*cli.Command{
-		{
-			Name: "unlock",
-			Action: func(c context.Context, cmd *cli.Command) error {
-				logrus.Debug("unlocking file")
-				filename := cmd.String("storage")
-				file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0o644)
-				errorutils.ExitOnFail(err)
-				logrus.Debug("file connection stablished")
-				return unlockFile(file)
-			},
-		},
+		showCmd,
+		runCmd,
+		serveCmd,
+		unlockCmd,
 	},
 }
 
@@ -77,40 +112,27 @@ func main() {
 	}
 }
 
-func superluminal(ctx context.Context, cmd *cli.Command) error {
-	wantsColor := cmd.Bool("enable-color")
-	if wantsColor != errorutils.ToggleColor() {
-		errorutils.ToggleColor()
-	}
+func superluminal(ctx context.Context, cmd *cli.Command) (err error) {
 	t := time.Now()
 	slog.Debug("starting log")
-	// open file to append
-	filename := cmd.String("storage")
-	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
-	errorutils.ExitOnFail(err)
+	level, err := parseLevel(cmd.String("level"))
+	if err != nil {
+		return errorutils.NewReport(err.Error(), "", errorutils.WithExitCode(2))
+	}
+	// an interrupt while waiting for the lock gives up instead of leaving the wait behind
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	entry, file, err := openLog(ctx, cmd)
+	if err != nil {
+		return err
+	}
 	defer func() {
-		file.Close()
-		unlockFile(file)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
 	}()
-
-	logrus.SetOutput(file)
-	entry := logrus.NewEntry(logrus.StandardLogger())
 	entry.Time = t // time of call not depending on mutex aquisition
 
-	var levelFunc func(args ...interface{})
-	switch cmd.String("level") {
-	case "debug":
-		levelFunc = entry.Debug
-	case "info":
-		levelFunc = entry.Info
-	case "warn":
-		levelFunc = entry.Warn
-	case "error":
-		levelFunc = entry.Error
-	default:
-		fmt.Fprintf(os.Stderr, "\x1b[31m%s\x1b[0m\n", "no such log level "+cmd.String("level"))
-	}
-
 	slog.Debug("program parameters set, moving on to processing input")
 	msg := strings.Join(cmd.Args().Slice(), " ")
 	// remove surrounding quotes from msg
@@ -153,12 +175,10 @@ func superluminal(ctx context.Context, cmd *cli.Command) error {
 		if !ok {
 			lineCH_open = false
 		}
-		err := lockFile(file)
-		if err != nil {
-			return err
+		entry.Log(level, line)
+		if file.Err() != nil {
+			return file.Err()
 		}
-		levelFunc(line)
-		unlockFile(file)
 	}
 
 lineRW:
@@ -169,32 +189,58 @@ lineRW:
 			lineCH_open = false
 			break lineRW
 		}
-		lockFile(file)
-		if err != nil {
-			return err
+		entry.Log(level, line)
+		if file.Err() != nil {
+			return file.Err()
 		}
-		levelFunc(line)
-		unlockFile(file)
 	}
 	return nil
 }
 
-func lockFile(file *os.File) error {
-	// if we wait for more than 3 minutes, we give up
-	ticker := time.NewTicker(3 * time.Minute)
-	defer ticker.Stop()
-	done := make(chan error)
-	go func() {
-		done <- syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
-	}()
-	select {
-	case err := <-done:
-		return err
-	case <-ticker.C:
-		return errTIMEOUT
+// openLog validates the flags shared by every writing command and returns the entry carrying their fields. Lines go to a running ansible server when there is one.
+func openLog(ctx context.Context, cmd *cli.Command) (*logrus.Entry, logOutput, error) {
+	wantsColor := cmd.Bool("enable-color")
+	if wantsColor != errorutils.ToggleColor() {
+		errorutils.ToggleColor()
+	}
+	formatter, err := newFormatter(cmd.String("format"), wantsColor)
+	if err != nil {
+		return nil, nil, errorutils.NewReport(err.Error(), "", errorutils.WithExitCode(2))
+	}
+	fields, err := parseFields(cmd.StringSlice("field"), cmd.String("tag"))
+	if err != nil {
+		return nil, nil, errorutils.NewReport(err.Error(), "", errorutils.WithExitCode(2))
+	}
+	rot, err := newRotation(cmd.String("rotate-size"), cmd.Duration("rotate-every"), int(cmd.Int("rotate-keep")), cmd.Bool("rotate-compress"))
+	if err != nil {
+		return nil, nil, errorutils.NewReport(err.Error(), "", errorutils.WithExitCode(2))
+	}
+	wantsFile, sinks, err := parseSinks(ctx, cmd.StringSlice("sink"), wantsColor)
+	if err != nil {
+		return nil, nil, errorutils.NewReport(err.Error(), "", errorutils.WithExitCode(2))
+	}
+	out := &fanout{sinks: sinks}
+	if wantsFile {
+		direct := func() (*storage, error) {
+			return openStorage(ctx, cmd.String("storage"), rot)
+		}
+		if client := dialServer(socketPath(cmd), direct); client != nil {
+			slog.Debug("writing through the ansible server")
+			out.primary = client
+		} else {
+			// open file to append
+			out.primary, err = direct()
+			errorutils.ExitOnFail(err)
+		}
 	}
-}
 
-func unlockFile(file *os.File) error {
-	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	// a logger of our own, the standard one keeps errorutils' formatting for messages to the terminal
+	logger := logrus.New()
+	logger.SetOutput(out)
+	logger.SetFormatter(formatter)
+	logger.SetLevel(logrus.TraceLevel)
+	for _, s := range sinks {
+		logger.AddHook(s)
+	}
+	return logger.WithFields(fields), out, nil
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rotation settings, the zero value never rotates. Rotated files are named path.1 (newest) up to path.keep, with a .gz suffix when compressed.
+type rotation struct {
+	size     int64         // rotate before a write would grow the file past size bytes
+	every    time.Duration // rotate when the last write happened in an earlier period of this length
+	keep     int
+	compress bool
+}
+
+func newRotation(size string, every time.Duration, keep int, compress bool) (rotation, error) {
+	r := rotation{every: every, keep: keep, compress: compress}
+	if size != "" {
+		var err error
+		if r.size, err = parseSize(size); err != nil {
+			return r, err
+		}
+	}
+	if r.every < 0 {
+		return r, fmt.Errorf("rotation period must be positive, got %s", every)
+	}
+	if r.enabled() && r.keep < 1 {
+		return r, fmt.Errorf("at least one rotated file must be kept, got %d", keep)
+	}
+	return r, nil
+}
+
+func (r rotation) enabled() bool {
+	return r.size > 0 || r.every > 0
+}
+
+// due never rotates an empty file. Periods are aligned to the zero time, so 24h rotates at midnight UTC.
+func (r rotation) due(info os.FileInfo, incoming int, now time.Time) bool {
+	if info.Size() == 0 {
+		return false
+	}
+	if r.size > 0 && info.Size()+int64(incoming) > r.size {
+		return true
+	}
+	return r.every > 0 && !info.ModTime().Truncate(r.every).Equal(now.Truncate(r.every))
+}
+
+func (r rotation) name(path string, i int, gz bool) string {
+	name := path + "." + strconv.Itoa(i)
+	if gz {
+		name += ".gz"
+	}
+	return name
+}
+
+// shift drops the oldest rotated file and moves the others one place up, leaving path.1 free.
+func (r rotation) shift(path string) error {
+	for i := r.keep; i >= 1; i-- {
+		for _, gz := range []bool{false, true} {
+			from := r.name(path, i, gz)
+			if _, err := os.Stat(from); os.IsNotExist(err) {
+				continue
+			}
+			var err error
+			if i == r.keep {
+				err = os.Remove(from)
+			} else {
+				err = os.Rename(from, r.name(path, i+1, gz))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compressFile replaces path with path.gz, going through a temporary file so a crash never leaves a truncated archive under the final name.
+func compressFile(path string) (err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := path + ".gz.tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	zw := gzip.NewWriter(out)
+	if _, err = io.Copy(zw, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// parseSize reads byte counts such as 512, 200K, 10M or 1G (powers of 1024).
+func parseSize(s string) (int64, error) {
+	num := strings.TrimSpace(strings.ToUpper(s))
+	num = strings.TrimSuffix(num, "B")
+	mult := int64(1)
+	if n := len(num); n > 0 {
+		switch num[n-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		}
+		if mult > 1 {
+			num = num[:n-1]
+		}
+	}
+	v, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid size %q, use a positive number with an optional K, M or G suffix", s)
+	}
+	return v * mult, nil
+}
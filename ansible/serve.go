@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pydpll/errorutils"
+	"github.com/urfave/cli/v3"
+)
+
+var serveCmd = &cli.Command{
+	Name:  "serve",
+	Usage: "listen on a unix socket and append the lines sent by other ansible invocations to the storage file",
+	Description: "ansible clients find the socket next to the storage file (or at --socket) and send their already formatted lines to it " +
+		"instead of opening and locking the file themselves. Without a server they lock the file as usual, the server keeps taking the flock so both can be mixed.",
+	Action: serve,
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "idle",
+			Usage: "exit after `DURATION` without clients, 0 serves until interrupted",
+		},
+	},
+}
+
+// socketPath defaults to the storage file with a .sock suffix so every storage gets its own server.
+func socketPath(cmd *cli.Command) string {
+	if p := cmd.String("socket"); p != "" {
+		return p
+	}
+	return cmd.String("storage") + ".sock"
+}
+
+// logOutput is where formatted lines go, either the storage file or a running server.
+type logOutput interface {
+	io.Writer
+	Close() error
+	Err() error
+}
+
+// serveRequest is a line to append or, with done set, the end of a connection asking for its outcome.
+type serveRequest struct {
+	line []byte
+	conn *serveConn
+	done chan error
+}
+
+type serveConn struct {
+	err  error // first failed write, only touched by the writer
+	lost int   // lines not written
+}
+
+func serve(ctx context.Context, cmd *cli.Command) error {
+	rot, err := newRotation(cmd.String("rotate-size"), cmd.Duration("rotate-every"), int(cmd.Int("rotate-keep")), cmd.Bool("rotate-compress"))
+	if err != nil {
+		return errorutils.NewReport(err.Error(), "", errorutils.WithExitCode(2))
+	}
+	out, err := openStorage(ctx, cmd.String("storage"), rot)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	path := socketPath(cmd)
+	listener, err := listenSocket(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	slog.Debug("serving " + cmd.String("storage") + " on " + path)
+
+	requests := make(chan serveRequest, 1024)
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		for req := range requests {
+			if req.done != nil {
+				req.done <- req.conn.err
+				continue
+			}
+			if _, err := out.Write(req.line); err != nil {
+				req.conn.lost++
+				if req.conn.err == nil {
+					req.conn.err = err
+				}
+			}
+		}
+	}()
+
+	var (
+		handlers sync.WaitGroup
+		mu       sync.Mutex
+		active   int
+		lastSeen = time.Now()
+	)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	shutdown := func() { stopOnce.Do(func() { close(stop); listener.Close() }) }
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case <-signals:
+			shutdown()
+		case <-ctx.Done():
+			shutdown()
+		case <-stop:
+		}
+	}()
+	if idle := cmd.Duration("idle"); idle > 0 {
+		go func() {
+			tick := time.NewTicker(idle / 10)
+			defer tick.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-tick.C:
+					mu.Lock()
+					expired := active == 0 && time.Since(lastSeen) > idle
+					mu.Unlock()
+					if expired {
+						slog.Debug("idle for " + idle.String() + ", shutting down")
+						shutdown()
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-stop:
+			default:
+				errorutils.WarnOnFail(err, errorutils.WithMsg("stopped accepting clients"))
+				shutdown()
+			}
+			break
+		}
+		mu.Lock()
+		active++
+		mu.Unlock()
+		handlers.Add(1)
+		go func() {
+			defer handlers.Done()
+			handleClient(conn, requests)
+			mu.Lock()
+			active--
+			lastSeen = time.Now()
+			mu.Unlock()
+		}()
+	}
+	handlers.Wait()
+	close(requests)
+	<-writerDone
+	return out.Err()
+}
+
+// listenSocket refuses to take over the socket of a running server but replaces one left behind by a dead server.
+func listenSocket(path string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("an ansible server is already listening on %s", path)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// handleClient queues every line of the connection, then reports whether all of them were written.
+func handleClient(conn net.Conn, requests chan<- serveRequest) {
+	defer conn.Close()
+	state := &serveConn{}
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			if line[len(line)-1] != '\n' {
+				line = append(line, '\n')
+			}
+			requests <- serveRequest{line: line, conn: state}
+		}
+		if err != nil {
+			if err != io.EOF {
+				errorutils.WarnOnFail(err, errorutils.WithMsg("client connection broke"))
+			}
+			break
+		}
+	}
+	done := make(chan error, 1)
+	requests <- serveRequest{conn: state, done: done}
+	if err := <-done; err != nil {
+		err = fmt.Errorf("%d lines not written: %w", state.lost, err)
+		errorutils.WarnOnFail(err, errorutils.WithMsg("lost lines of a client"))
+		fmt.Fprintf(conn, "error: %s\n", strings.ReplaceAll(err.Error(), "\n", " "))
+		return
+	}
+	fmt.Fprint(conn, "ok\n")
+}
+
+// socketClient sends lines to a running server. If the server goes away mid run the remaining lines go straight to the storage file,
+// with the sent ones it never confirmed: it may have died before writing them.
+type socketClient struct {
+	conn        *net.UnixConn
+	fallback    func() (*storage, error)
+	direct      *storage
+	unconfirmed [][]byte
+	dropped     int // unconfirmed lines beyond maxUnconfirmed, forgotten
+	err         error
+}
+
+const (
+	// maxUnconfirmed bounds the memory of a long run, the server confirms only when the client closes.
+	maxUnconfirmed = 10000
+	// confirmTimeout outlasts the server waiting for the flock, a client giving up first would write lines the server still writes.
+	confirmTimeout = lockTimeout + time.Minute
+)
+
+// dialServer returns nil when no server listens on path.
+func dialServer(path string, fallback func() (*storage, error)) *socketClient {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil
+	}
+	return &socketClient{conn: conn.(*net.UnixConn), fallback: fallback}
+}
+
+func (c *socketClient) Write(p []byte) (int, error) {
+	if c.direct == nil {
+		if n, err := c.conn.Write(p); err == nil {
+			c.keep(p)
+			return n, nil
+		}
+		if err := c.takeOver(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := c.direct.Write(p)
+	c.setErr(err)
+	return n, err
+}
+
+func (c *socketClient) keep(line []byte) {
+	if len(c.unconfirmed) == maxUnconfirmed {
+		c.unconfirmed = c.unconfirmed[1:]
+		c.dropped++
+	}
+	c.unconfirmed = append(c.unconfirmed, bytes.Clone(line))
+}
+
+// takeOver opens the storage file after losing the server and writes the unconfirmed lines again, some may appear twice.
+func (c *socketClient) takeOver() error {
+	c.conn.Close()
+	var err error
+	if c.direct, err = c.fallback(); err != nil {
+		c.setErr(err)
+		return err
+	}
+	msg := fmt.Sprintf("lost the ansible server, writing to the storage file directly and again the %d lines it did not confirm", len(c.unconfirmed))
+	if c.dropped > 0 {
+		msg += fmt.Sprintf(", %d older lines may be lost", c.dropped)
+	}
+	slog.Warn(msg)
+	for _, line := range c.unconfirmed {
+		if _, err := c.direct.Write(line); err != nil {
+			c.setErr(err)
+			return err
+		}
+	}
+	c.unconfirmed = nil
+	return nil
+}
+
+func (c *socketClient) setErr(err error) {
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+}
+
+// Close waits for the server to confirm every line was written.
+func (c *socketClient) Close() error {
+	if c.direct != nil {
+		return c.direct.Close()
+	}
+	defer c.conn.Close()
+	if err := c.conn.CloseWrite(); err != nil {
+		c.setErr(err)
+		return err
+	}
+	c.conn.SetReadDeadline(time.Now().Add(confirmTimeout))
+	reply, err := bufio.NewReader(c.conn).ReadString('\n')
+	if err != nil {
+		slog.Debug("no confirmation from the ansible server: " + err.Error())
+		if err := c.takeOver(); err != nil {
+			return err
+		}
+		return c.direct.Close()
+	}
+	if reply = strings.TrimSpace(reply); reply != "ok" {
+		err = errors.New("ansible server: " + strings.TrimPrefix(reply, "error: "))
+		c.setErr(err)
+		return err
+	}
+	return nil
+}
+
+func (c *socketClient) Err() error {
+	return c.err
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+func TestServerDiesMidStream(t *testing.T) {
+	dir := t.TempDir()
+	path, sock := filepath.Join(dir, "ansible.log"), filepath.Join(dir, "ansible.log.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// a server reading the first line and dying before it writes anything
+	died := make(chan struct{})
+	go func() {
+		defer close(died)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+	}()
+
+	client := dialServer(sock, func() (*storage, error) { return openStorage(context.Background(), path, rotation{}) })
+	if client == nil {
+		t.Fatal("no server found")
+	}
+	lines := []string{"read by the server\n", "sent as it died\n", "after it died\n"}
+	client.Write([]byte(lines[0]))
+	<-died
+	for _, line := range lines[1:] {
+		if _, err := client.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != lines[0]+lines[1]+lines[2] {
+		t.Fatalf("storage holds %q", data)
+	}
+}
+
+func TestServerWaitingForTheLock(t *testing.T) {
+	dir := t.TempDir()
+	path, sock := filepath.Join(dir, "ansible.log"), filepath.Join(dir, "ansible.log.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		cmd := app
+		cmd.ExitErrHandler = func(context.Context, *cli.Command, error) {}
+		served <- cmd.Run(ctx, []string{"ansible", "--storage", path, "serve"})
+	}()
+	defer func() {
+		cancel()
+		if err := <-served; err != nil {
+			t.Error(err)
+		}
+	}()
+	var client *socketClient
+	for range 100 {
+		if client = dialServer(sock, func() (*storage, error) { return openStorage(ctx, path, rotation{}) }); client != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if client == nil {
+		t.Fatal("server never listened")
+	}
+
+	// another writer holds the storage file while the client finishes
+	held, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+	if err := syscall.Flock(int(held.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{"first\n", "second\n"}
+	for _, line := range lines {
+		if _, err := client.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unlocked := make(chan struct{})
+	go func() {
+		defer close(unlocked)
+		time.Sleep(500 * time.Millisecond)
+		syscall.Flock(int(held.Fd()), syscall.LOCK_UN)
+	}()
+	defer func() { <-unlocked }()
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if client.direct != nil {
+		t.Fatal("the client wrote the lines itself")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != lines[0]+lines[1] {
+		t.Fatalf("storage holds %q", data)
+	}
+}
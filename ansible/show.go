@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pydpll/errorutils"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v3"
+)
+
+var showCmd = &cli.Command{
+	Name:    "show",
+	Aliases: []string{"tail"},
+	Usage:   "print the lines of the storage file, filtered by --level (and above), --tag, time and text",
+	Action:  show,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "since",
+			Usage: "only lines newer than `WHEN`, a duration (2h, 30m) or a time (2006-01-02 15:04:05, RFC3339)",
+		},
+		&cli.StringFlag{
+			Name:    "grep",
+			Aliases: []string{"g"},
+			Usage:   "only lines whose message or fields contain `TEXT`",
+		},
+		&cli.IntFlag{
+			Name:    "lines",
+			Aliases: []string{"n"},
+			Usage:   "only the last `N` matching lines, 0 shows them all",
+		},
+		&cli.BoolFlag{
+			Name:    "follow",
+			Aliases: []string{"F"},
+			Usage:   "keep printing new lines as they are written, across rotations",
+		},
+	},
+}
+
+// record is a storage line in any of the formats ansible writes.
+type record struct {
+	Time    time.Time
+	Level   logrus.Level
+	Message string
+	Fields  map[string]string
+}
+
+type recordFilter struct {
+	minLevel logrus.Level
+	byLevel  bool
+	since    time.Time
+	tag      string
+	grep     string
+}
+
+func (f recordFilter) match(r record) bool {
+	if f.byLevel && r.Level > f.minLevel { // logrus levels grow with verbosity
+		return false
+	}
+	if !f.since.IsZero() && r.Time.Before(f.since) {
+		return false
+	}
+	if f.tag != "" && r.Fields["tag"] != f.tag {
+		return false
+	}
+	if f.grep != "" {
+		if strings.Contains(r.Message, f.grep) {
+			return true
+		}
+		for _, v := range r.Fields {
+			if strings.Contains(v, f.grep) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func show(ctx context.Context, cmd *cli.Command) error {
+	filter := recordFilter{tag: cmd.String("tag"), grep: cmd.String("grep")}
+	if cmd.IsSet("level") {
+		level, err := parseLevel(cmd.String("level"))
+		if err != nil {
+			return errorutils.NewReport(err.Error(), "", errorutils.WithExitCode(2))
+		}
+		filter.minLevel, filter.byLevel = level, true
+	}
+	if since := cmd.String("since"); since != "" {
+		t, err := parseSince(since, time.Now())
+		if err != nil {
+			return errorutils.NewReport(err.Error(), "", errorutils.WithExitCode(2))
+		}
+		filter.since = t
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	printer := &textFormatter{Color: cmd.Bool("enable-color")}
+	emit := func(r record) error {
+		line, _ := printer.Format(&logrus.Entry{Time: r.Time, Level: r.Level, Message: r.Message, Data: r.data()})
+		_, err := out.Write(line)
+		return err
+	}
+
+	path := cmd.String("storage")
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+	reader := bufio.NewReader(file)
+
+	// existing lines, only the last n are kept when asked to
+	n := int(cmd.Int("lines"))
+	var last []record
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF && !cmd.Bool("follow") && line != "" {
+			err = nil // last line without newline
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err == nil {
+			if r, ok := parseRecord(line); ok && filter.match(r) {
+				if n > 0 {
+					last = append(last, r)
+					if len(last) > n {
+						last = last[1:]
+					}
+				} else if err := emit(r); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		// EOF, line holds a partial line still being written
+		for _, r := range last {
+			if err := emit(r); err != nil {
+				return err
+			}
+		}
+		if !cmd.Bool("follow") {
+			return nil
+		}
+		out.Flush()
+		return follow(ctx, path, file, reader, line, filter, emit, out.Flush)
+	}
+}
+
+// follow polls the storage file for new lines. When the path is rotated away or truncated it starts over from the beginning of the new file.
+func follow(ctx context.Context, path string, file *os.File, reader *bufio.Reader, partial string, filter recordFilter, emit func(record) error, flush func() error) error {
+	tick := time.NewTicker(250 * time.Millisecond)
+	defer tick.Stop()
+	drain := func() error {
+		for {
+			chunk, err := reader.ReadString('\n')
+			partial += chunk
+			if err == io.EOF {
+				return flush()
+			}
+			if err != nil {
+				return err
+			}
+			if r, ok := parseRecord(partial); ok && filter.match(r) {
+				if err := emit(r); err != nil {
+					return err
+				}
+			}
+			partial = ""
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C:
+		}
+		if err := drain(); err != nil {
+			return err
+		}
+		current, err := os.Stat(path)
+		if err != nil {
+			continue // between a rotation's rename and the next write
+		}
+		ours, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		offset, _ := file.Seek(0, io.SeekCurrent)
+		if os.SameFile(current, ours) && current.Size() >= offset {
+			continue
+		}
+		next, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		// rotated or truncated, anything written to the old file before it was replaced still gets printed
+		if err := drain(); err != nil {
+			return err
+		}
+		file.Close()
+		file, partial = next, ""
+		reader.Reset(file)
+	}
+}
+
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation(textTimeFormat, s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("--since %q is neither a duration nor a time", s)
+}
+
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// parseRecord detects the format of each line on its own, a file may mix them after a change of --format.
+func parseRecord(line string) (record, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	switch {
+	case line == "":
+		return record{}, false
+	case strings.HasPrefix(line, "{"):
+		return parseJSONRecord(line)
+	case strings.HasPrefix(line, "time="):
+		return parseLogfmtRecord(line)
+	default:
+		return parseTextRecord(line)
+	}
+}
+
+func parseTextRecord(line string) (record, bool) {
+	line = ansiEscape.ReplaceAllString(line, "")
+	if len(line) < len(textTimeFormat)+8 {
+		return record{}, false
+	}
+	t, err := time.ParseInLocation(textTimeFormat, line[:len(textTimeFormat)], time.Local)
+	if err != nil {
+		return record{}, false
+	}
+	rest := line[len(textTimeFormat):]
+	if !strings.HasPrefix(rest, " [") || rest[6] != ']' {
+		return record{}, false
+	}
+	level, ok := labelLevel(rest[2:6])
+	if !ok {
+		return record{}, false
+	}
+	r := record{Time: t, Level: level, Message: strings.TrimPrefix(rest[7:], " ")}
+	// fields follow the last tab, a tab in the message itself leaves a tail that is not all key=value pairs
+	if i := strings.LastIndexByte(r.Message, '\t'); i >= 0 {
+		if fields, ok := scanLogfmt(r.Message[i+1:]); ok {
+			r.Message, r.Fields = r.Message[:i], fields
+		}
+	}
+	return r, true
+}
+
+func labelLevel(label string) (logrus.Level, bool) {
+	for _, l := range logrus.AllLevels {
+		if strings.ToUpper(l.String())[0:4] == label {
+			return l, true
+		}
+	}
+	return 0, false
+}
+
+func parseJSONRecord(line string) (record, bool) {
+	var data map[string]any
+	if err := json.Unmarshal([]byte(line), &data); err != nil {
+		return record{}, false
+	}
+	fields := make(map[string]string, len(data))
+	for k, v := range data {
+		if s, ok := v.(string); ok {
+			fields[k] = s
+		} else {
+			fields[k] = fmt.Sprint(v)
+		}
+	}
+	return fieldsRecord(fields)
+}
+
+func parseLogfmtRecord(line string) (record, bool) {
+	return fieldsRecord(parseLogfmt(line))
+}
+
+// fieldsRecord pulls time, level and msg out of a structured line, everything else stays a field.
+func fieldsRecord(fields map[string]string) (record, bool) {
+	t, err := time.Parse(time.RFC3339Nano, fields["time"])
+	if err != nil {
+		return record{}, false
+	}
+	level, err := logrus.ParseLevel(fields["level"])
+	if err != nil {
+		return record{}, false
+	}
+	r := record{Time: t, Level: level, Message: fields["msg"], Fields: fields}
+	delete(fields, "time")
+	delete(fields, "level")
+	delete(fields, "msg")
+	return r, true
+}
+
+// parseLogfmt reads space separated key=value pairs, values may be Go quoted strings.
+func parseLogfmt(s string) map[string]string {
+	fields, _ := scanLogfmt(s)
+	return fields
+}
+
+// scanLogfmt is parseLogfmt telling whether all of s was pairs, without bare keys or broken quotes.
+func scanLogfmt(s string) (map[string]string, bool) {
+	fields := make(map[string]string)
+	whole := true
+	for s = strings.TrimLeft(s, " "); s != ""; s = strings.TrimLeft(s, " ") {
+		eq := strings.IndexAny(s, "= ")
+		if eq < 0 || s[eq] == ' ' {
+			// a bare key
+			whole = false
+			end := strings.IndexByte(s, ' ')
+			if end < 0 {
+				end = len(s)
+			}
+			fields[s[:end]] = ""
+			s = s[end:]
+			continue
+		}
+		key := s[:eq]
+		whole = whole && key != ""
+		s = s[eq+1:]
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			quoted, err := strconv.QuotedPrefix(s)
+			if err == nil {
+				value, _ = strconv.Unquote(quoted)
+				s = s[len(quoted):]
+				fields[key] = value
+				continue
+			}
+			whole = false
+		}
+		end := strings.IndexByte(s, ' ')
+		if end < 0 {
+			end = len(s)
+		}
+		value, s = s[:end], s[end:]
+		fields[key] = value
+	}
+	return fields, whole && len(fields) > 0
+}
+
+func (r record) data() logrus.Fields {
+	data := make(logrus.Fields, len(r.Fields))
+	for k, v := range r.Fields {
+		data[k] = v
+	}
+	return data
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestParseTextRecord(t *testing.T) {
+	at := time.Date(2024, 5, 6, 7, 8, 9, 0, time.Local)
+	for _, tc := range []struct {
+		line    string
+		ok      bool
+		level   logrus.Level
+		message string
+		fields  map[string]string
+	}{
+		{"2024-05-06 07:08:09 [INFO] backup done", true, logrus.InfoLevel, "backup done", nil},
+		{"2024-05-06 07:08:09 [WARN] disk low\tpath=/data tag=backup", true, logrus.WarnLevel, "disk low", map[string]string{"path": "/data", "tag": "backup"}},
+		{`2024-05-06 07:08:09 [ERRO] failed	err="exit status 1" tag=""`, true, logrus.ErrorLevel, "failed", map[string]string{"err": "exit status 1", "tag": ""}},
+		{"2024-05-06 07:08:09 [INFO] name\tsize\tfile.txt\t42", true, logrus.InfoLevel, "name\tsize\tfile.txt\t42", nil},
+		{"2024-05-06 07:08:09 [INFO] a\tb=1 c\ttag=x", true, logrus.InfoLevel, "a\tb=1 c", map[string]string{"tag": "x"}},
+		{"2024-05-06 07:08:09 [INFO] ratio\t=3", true, logrus.InfoLevel, "ratio\t=3", nil},
+		{"2024-05-06 07:08:09 [\x1b[33mWARN\x1b[0m] colored\ttag=x", true, logrus.WarnLevel, "colored", map[string]string{"tag": "x"}},
+		{"2024-05-06 07:08:09 [DEBU] ", true, logrus.DebugLevel, "", nil},
+		{"2024-05-06 07:08:09 [NOPE] level", false, 0, "", nil},
+		{"yesterday [INFO] no time", false, 0, "", nil},
+		{"2024-05-06 07:08:09 INFO missing brackets", false, 0, "", nil},
+	} {
+		r, ok := parseTextRecord(tc.line)
+		if ok != tc.ok {
+			t.Errorf("%q: ok %v", tc.line, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if !r.Time.Equal(at) || r.Level != tc.level || r.Message != tc.message || !maps.Equal(r.Fields, tc.fields) {
+			t.Errorf("%q: got %v %v %q %v", tc.line, r.Time, r.Level, r.Message, r.Fields)
+		}
+	}
+}
+
+func TestParseJSONRecord(t *testing.T) {
+	for _, tc := range []struct {
+		line    string
+		ok      bool
+		level   logrus.Level
+		message string
+		fields  map[string]string
+	}{
+		{`{"time":"2024-05-06T07:08:09Z","level":"info","msg":"done"}`, true, logrus.InfoLevel, "done", map[string]string{}},
+		{`{"time":"2024-05-06T07:08:09.5Z","level":"warning","msg":"a\tb","tag":"x","n":3,"ok":true}`, true, logrus.WarnLevel, "a\tb", map[string]string{"tag": "x", "n": "3", "ok": "true"}},
+		{`{"time":"2024-05-06T07:08:09Z","level":"loud","msg":"x"}`, false, 0, "", nil},
+		{`{"level":"info","msg":"no time"}`, false, 0, "", nil},
+		{`{"time":"2024-05-06T07:08:09Z",`, false, 0, "", nil},
+	} {
+		r, ok := parseJSONRecord(tc.line)
+		if ok != tc.ok {
+			t.Errorf("%s: ok %v", tc.line, ok)
+			continue
+		}
+		if ok && (r.Level != tc.level || r.Message != tc.message || !maps.Equal(r.Fields, tc.fields)) {
+			t.Errorf("%s: got %v %q %v", tc.line, r.Level, r.Message, r.Fields)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pydpll/errorutils"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultSyslogSocket = "/dev/log"
+
+// sink is a destination besides the storage file. It gets every entry as a logrus hook, before the storage line is written.
+type sink interface {
+	logrus.Hook
+	Close() error
+	Err() error
+}
+
+// parseSinks reads KIND[=TARGET] specs. It reports whether the storage file is wanted and opens the other destinations.
+// A missing syslog socket only warns, the same flags have to work on machines without a syslog daemon.
+func parseSinks(ctx context.Context, specs []string, color bool) (bool, []sink, error) {
+	if len(specs) == 0 {
+		return true, nil, nil
+	}
+	var (
+		wantsFile bool
+		sinks     []sink
+	)
+	fail := func(err error) (bool, []sink, error) {
+		for _, s := range sinks {
+			s.Close()
+		}
+		return false, nil, err
+	}
+	for _, spec := range specs {
+		kind, target, _ := strings.Cut(spec, "=")
+		switch kind {
+		case "file":
+			if target != "" {
+				return fail(fmt.Errorf("sink %q: the file sink writes to --storage", spec))
+			}
+			wantsFile = true
+		case "stderr":
+			sinks = append(sinks, &writerSink{out: os.Stderr, formatter: &textFormatter{Color: color}})
+		case "syslog":
+			if target == "" {
+				target = defaultSyslogSocket
+			}
+			s, err := dialSyslog(target)
+			if err != nil {
+				errorutils.WarnOnFail(err, errorutils.WithMsg("skipping the syslog sink"))
+				continue
+			}
+			sinks = append(sinks, s)
+		case "journal":
+			if target == "" {
+				return fail(fmt.Errorf("sink %q: the journal sink needs a file, journal=PATH", spec))
+			}
+			file, err := openStorage(ctx, target, rotation{})
+			if err != nil {
+				return fail(err)
+			}
+			sinks = append(sinks, &writerSink{out: file, formatter: journalFormatter{}, closer: file})
+		default:
+			return fail(fmt.Errorf("no such sink %q, possible values: file, stderr, syslog[=SOCKET], journal=PATH", spec))
+		}
+	}
+	return wantsFile, sinks, nil
+}
+
+// writerSink formats entries on its own, the storage format does not apply to it.
+type writerSink struct {
+	out       interface{ Write([]byte) (int, error) }
+	formatter logrus.Formatter
+	closer    interface{ Close() error }
+	err       error
+}
+
+func (s *writerSink) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (s *writerSink) Fire(entry *logrus.Entry) error {
+	line, err := s.formatter.Format(entry)
+	if err == nil {
+		_, err = s.out.Write(line)
+	}
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	return err
+}
+
+func (s *writerSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+func (s *writerSink) Err() error {
+	return s.err
+}
+
+// syslogSink sends RFC 5424 messages to a local socket, datagram like /dev/log or newline framed stream.
+type syslogSink struct {
+	conn     net.Conn
+	stream   bool
+	hostname string
+	err      error
+}
+
+func dialSyslog(path string) (*syslogSink, error) {
+	hostname, _ := os.Hostname()
+	if conn, err := net.Dial("unixgram", path); err == nil {
+		return &syslogSink{conn: conn, hostname: hostname}, nil
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("no syslog socket at %s: %w", path, err)
+	}
+	return &syslogSink{conn: conn, stream: true, hostname: hostname}, nil
+}
+
+func (s *syslogSink) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (s *syslogSink) Fire(entry *logrus.Entry) error {
+	msg := syslogMessage(entry, s.hostname, os.Getpid())
+	if s.stream {
+		msg = append(msg, '\n')
+	}
+	_, err := s.conn.Write(msg)
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	return err
+}
+
+func (s *syslogSink) Close() error {
+	return s.conn.Close()
+}
+
+func (s *syslogSink) Err() error {
+	return s.err
+}
+
+// syslogEnterpriseID is the example private enterprise number of RFC 5612, fields go in an SD-ELEMENT named after it.
+const syslogEnterpriseID = 32473
+
+func syslogSeverity(level logrus.Level) int {
+	switch level {
+	case logrus.PanicLevel:
+		return 0
+	case logrus.FatalLevel:
+		return 2
+	case logrus.ErrorLevel:
+		return 3
+	case logrus.WarnLevel:
+		return 4
+	case logrus.InfoLevel:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// syslogMessage renders `<PRI>1 TIMESTAMP HOST APP PID - [SD] MSG` with the user facility, the tag field becomes the APP-NAME.
+func syslogMessage(entry *logrus.Entry, hostname string, pid int) []byte {
+	const userFacility = 1
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "<%d>1 %s %s %s %d - ",
+		userFacility*8+syslogSeverity(entry.Level),
+		entry.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeader(hostname, 255),
+		syslogHeader(appName(entry), 48),
+		pid)
+	keys := fieldKeys(entry.Data)
+	if len(keys) == 0 {
+		b.WriteByte('-')
+	} else {
+		fmt.Fprintf(b, "[ansible@%d", syslogEnterpriseID)
+		for _, k := range keys {
+			b.WriteByte(' ')
+			b.WriteString(syslogParamName(k))
+			b.WriteString(`="`)
+			b.WriteString(syslogParamValue.Replace(fmt.Sprint(entry.Data[k])))
+			b.WriteByte('"')
+		}
+		b.WriteByte(']')
+	}
+	b.WriteByte(' ')
+	b.WriteString(entry.Message)
+	return b.Bytes()
+}
+
+func appName(entry *logrus.Entry) string {
+	if tag, ok := entry.Data["tag"].(string); ok && tag != "" {
+		return tag
+	}
+	return "ansible"
+}
+
+func fieldKeys(data logrus.Fields) []string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// syslogHeader keeps printable ASCII without spaces, "-" stands for an empty value.
+func syslogHeader(s string, max int) string {
+	out := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, s)
+	if len(out) > max {
+		out = out[:max]
+	}
+	if out == "" {
+		return "-"
+	}
+	return out
+}
+
+func syslogParamName(s string) string {
+	out := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, s)
+	if len(out) > 32 {
+		out = out[:32]
+	}
+	return out
+}
+
+var syslogParamValue = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// journalFormatter writes the systemd journal export format, ready for systemd-journal-remote or journalctl --file after import.
+type journalFormatter struct{}
+
+func (journalFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	hostname, _ := os.Hostname()
+	b := &bytes.Buffer{}
+	journalField(b, "__REALTIME_TIMESTAMP", strconv.FormatInt(entry.Time.UnixMicro(), 10))
+	journalField(b, "_PID", strconv.Itoa(os.Getpid()))
+	journalField(b, "_HOSTNAME", hostname)
+	journalField(b, "PRIORITY", strconv.Itoa(syslogSeverity(entry.Level)))
+	journalField(b, "SYSLOG_IDENTIFIER", appName(entry))
+	journalField(b, "MESSAGE", entry.Message)
+	for _, k := range fieldKeys(entry.Data) {
+		journalField(b, journalFieldName(k), fmt.Sprint(entry.Data[k]))
+	}
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+// journalField uses the binary form, name, newline, little endian length, data, for values a plain line could not hold.
+func journalField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if strings.IndexFunc(value, func(r rune) bool { return r == '\n' || r < 32 && r != '\t' }) < 0 {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// journalFieldName maps a field key to the uppercase letters, digits and underscores the journal allows, without the leading underscore of trusted fields.
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	if name == "" || name[0] == '_' || name[0] >= '0' && name[0] <= '9' {
+		name = "F" + strings.TrimLeft(name, "_")
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// fanout is the logOutput of an entry going to several destinations, primary is nil without the file sink.
+type fanout struct {
+	primary logOutput
+	sinks   []sink
+}
+
+func (f *fanout) Write(p []byte) (int, error) {
+	if f.primary == nil {
+		return len(p), nil
+	}
+	return f.primary.Write(p)
+}
+
+func (f *fanout) Close() error {
+	var errs []error
+	if f.primary != nil {
+		errs = append(errs, f.primary.Close())
+	}
+	for _, s := range f.sinks {
+		errs = append(errs, s.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Err is the first failure of any destination.
+func (f *fanout) Err() error {
+	if f.primary != nil && f.primary.Err() != nil {
+		return f.primary.Err()
+	}
+	for _, s := range f.sinks {
+		if s.Err() != nil {
+			return s.Err()
+		}
+	}
+	return nil
+}
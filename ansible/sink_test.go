@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// logThrough sends one entry to the sinks of specs the way openLog wires them.
+func logThrough(t *testing.T, specs []string, msg string, fields logrus.Fields) {
+	t.Helper()
+	wantsFile, sinks, err := parseSinks(context.Background(), specs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wantsFile {
+		t.Fatalf("%v asked for the storage file", specs)
+	}
+	out := &fanout{sinks: sinks}
+	logger := logrus.New()
+	logger.SetOutput(out)
+	for _, s := range sinks {
+		logger.AddHook(s)
+	}
+	logger.WithFields(fields).WithTime(time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)).Warn(msg)
+	if err := out.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyslogDatagram(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	logThrough(t, []string{"syslog=" + path}, "disk almost full", logrus.Fields{"tag": "backup", "quote": `a "b" ]`})
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostname, _ := os.Hostname()
+	want := "<12>1 2024-05-06T07:08:09.000000Z " + hostname + " backup " + strconv.Itoa(os.Getpid()) +
+		` - [ansible@32473 quote="a \"b\" \]" tag="backup"] disk almost full`
+	if got := string(buf[:n]); got != want {
+		t.Fatalf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestSyslogStream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- line
+	}()
+
+	logThrough(t, []string{"syslog=" + path}, "plain", nil)
+	select {
+	case line := <-received:
+		if !strings.HasPrefix(line, "<12>1 ") || !strings.HasSuffix(line, " ansible "+strconv.Itoa(os.Getpid())+" - - plain\n") {
+			t.Fatalf("got %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nothing received")
+	}
+}
+
+func TestSyslogMissingSocket(t *testing.T) {
+	wantsFile, sinks, err := parseSinks(context.Background(), []string{"file", "syslog=" + filepath.Join(t.TempDir(), "none")}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wantsFile || len(sinks) != 0 {
+		t.Fatalf("got file %v and %d sinks, want the file alone", wantsFile, len(sinks))
+	}
+}
+
+func TestJournalExport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ansible.journal")
+	logThrough(t, []string{"journal=" + path}, "two\nlines", logrus.Fields{"job-id": 7})
+	logThrough(t, []string{"journal=" + path}, "second", nil)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := readJournalExport(t, data)
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	first := entries[0]
+	for key, want := range map[string]string{
+		"MESSAGE":              "two\nlines",
+		"PRIORITY":             "4",
+		"SYSLOG_IDENTIFIER":    "ansible",
+		"JOB_ID":               "7",
+		"__REALTIME_TIMESTAMP": strconv.FormatInt(time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC).UnixMicro(), 10),
+	} {
+		if first[key] != want {
+			t.Errorf("%s = %q, want %q", key, first[key], want)
+		}
+	}
+	if entries[1]["MESSAGE"] != "second" {
+		t.Errorf("second MESSAGE = %q", entries[1]["MESSAGE"])
+	}
+}
+
+// readJournalExport parses both the text and the binary field forms.
+func readJournalExport(t *testing.T, data []byte) []map[string]string {
+	t.Helper()
+	var entries []map[string]string
+	entry := map[string]string{}
+	r := bufio.NewReader(bytes.NewReader(data))
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			entries = append(entries, entry)
+			entry = map[string]string{}
+			continue
+		}
+		if name, value, ok := strings.Cut(line, "="); ok {
+			entry[name] = value
+			continue
+		}
+		var size uint64
+		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+			t.Fatal(err)
+		}
+		value := make([]byte, size+1)
+		if _, err := io.ReadFull(r, value); err != nil {
+			t.Fatal(err)
+		}
+		entry[line] = string(value[:size])
+	}
+	return entries
+}
+
+func TestStderrSink(t *testing.T) {
+	var buf bytes.Buffer
+	s := &writerSink{out: &buf, formatter: &textFormatter{}}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.AddHook(s)
+	logger.WithTime(time.Date(2024, 5, 6, 7, 8, 9, 0, time.Local)).WithField("tag", "x").Error("boom")
+	if got, want := buf.String(), "2024-05-06 07:08:09 [ERRO] boom\ttag=x\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestUnknownSink(t *testing.T) {
+	for _, spec := range []string{"kafka", "journal", "file=/tmp/x"} {
+		if _, _, err := parseSinks(context.Background(), []string{spec}, false); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
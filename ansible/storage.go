@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// storage is the log file shared by every ansible process writing to the same path. Each Write happens under flock, after making sure the locked file is still the one at path: a concurrent rotation or `ansible unlock` may have replaced it while we waited.
+type storage struct {
+	ctx    context.Context
+	path   string
+	file   *os.File
+	holder *os.File // path.lock, tells `ansible unlock` who has the flock
+	rot    rotation
+	err    error // first failed write, logrus only reports those on stderr
+}
+
+// openStorage ties lock waits to ctx, a cancelled ctx makes pending and later writes fail.
+func openStorage(ctx context.Context, path string, rot rotation) (*storage, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	holder, err := os.OpenFile(holderPath(path), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &storage{ctx: ctx, path: path, file: file, holder: holder, rot: rot}, nil
+}
+
+func (s *storage) Write(p []byte) (int, error) {
+	n, err := s.write(p)
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	return n, err
+}
+
+func (s *storage) write(p []byte) (int, error) {
+	if err := s.lock(); err != nil {
+		return 0, err
+	}
+	defer func() { unlockFile(s.file) }() // rotate swaps s.file for a locked fresh one
+	if s.rot.enabled() {
+		info, err := s.file.Stat()
+		if err != nil {
+			return 0, err
+		}
+		if s.rot.due(info, len(p), time.Now()) {
+			if err := s.rotate(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return s.file.Write(p)
+}
+
+// lock returns holding the flock of the file currently at path, reopening it as many times as needed. Waiting ends with the context or after lockTimeout.
+func (s *storage) lock() error {
+	ctx, cancel := context.WithTimeout(s.ctx, lockTimeout)
+	defer cancel()
+	for {
+		if err := lockFile(ctx, s.file, s.replaced); err != nil {
+			if err == errReplaced {
+				if err := s.reopen(); err != nil {
+					return err
+				}
+				continue
+			}
+			return lockError(err, s.path)
+		}
+		if !s.replaced() {
+			writeHolder(s.holder)
+			return nil
+		}
+		unlockFile(s.file)
+		if err := s.reopen(); err != nil {
+			return err
+		}
+	}
+}
+
+// replaced reports whether path no longer names our file. A missing path counts as replaced, the reopen creates it.
+func (s *storage) replaced() bool {
+	current, err := os.Stat(s.path)
+	if err != nil {
+		return true
+	}
+	ours, err := s.file.Stat()
+	return err != nil || !os.SameFile(current, ours)
+}
+
+func (s *storage) reopen() error {
+	s.file.Close()
+	var err error
+	s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	return err
+}
+
+// rotate runs with the flock held. The new file is locked before the old one is let go, so compression and retention happen while nobody else can write or rotate.
+func (s *storage) rotate() error {
+	if err := s.rot.shift(s.path); err != nil {
+		return err
+	}
+	if err := os.Rename(s.path, s.rot.name(s.path, 1, false)); err != nil {
+		return err
+	}
+	fresh, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, lockTimeout)
+	defer cancel()
+	if err := lockFile(ctx, fresh, nil); err != nil {
+		fresh.Close()
+		return lockError(err, s.path)
+	}
+	writeHolder(s.holder)
+	unlockFile(s.file)
+	s.file.Close()
+	s.file = fresh
+	if s.rot.compress {
+		return compressFile(s.rot.name(s.path, 1, false))
+	}
+	return nil
+}
+
+func (s *storage) Close() error {
+	s.holder.Close()
+	return s.file.Close()
+}
+
+func (s *storage) Err() error {
+	return s.err
+}
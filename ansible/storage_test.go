@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeConcurrently runs writers storages on path, each appending lines numbered lines.
+func writeConcurrently(t *testing.T, path string, rot rotation, writers, lines int) {
+	t.Helper()
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := openStorage(context.Background(), path, rot)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer s.Close()
+			for i := range lines {
+				if _, err := fmt.Fprintf(s, "writer=%d line=%d %s\n", w, i, strings.Repeat("x", 64)); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+// countLines checks every line of the files is whole and returns how many each writer left.
+func countLines(t *testing.T, files ...string) map[string]int {
+	t.Helper()
+	seen := make(map[string]int)
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			var w, i int
+			var pad string
+			if n, _ := fmt.Sscanf(s.Text(), "writer=%d line=%d %s", &w, &i, &pad); n != 3 || len(pad) != 64 {
+				t.Fatalf("%s: torn line %q", name, s.Text())
+			}
+			seen[fmt.Sprint(w, i)]++
+		}
+		f.Close()
+	}
+	return seen
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ansible.log")
+	writeConcurrently(t, path, rotation{}, 8, 200)
+	seen := countLines(t, path)
+	if len(seen) != 8*200 {
+		t.Fatalf("got %d distinct lines, want %d", len(seen), 8*200)
+	}
+	for line, n := range seen {
+		if n != 1 {
+			t.Fatalf("line %s written %d times", line, n)
+		}
+	}
+	h, err := readHolder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Pid != os.Getpid() {
+		t.Fatalf("holder pid %d, want %d", h.Pid, os.Getpid())
+	}
+}
+
+func TestConcurrentWritersRotating(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ansible.log")
+	rot := rotation{size: 4 << 10, keep: 1000}
+	writeConcurrently(t, path, rot, 6, 150)
+	files, err := filepath.Glob(path + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs []string
+	for _, f := range files {
+		if !strings.HasSuffix(f, ".lock") {
+			logs = append(logs, f)
+		}
+	}
+	if len(logs) < 2 {
+		t.Fatalf("expected rotated files, got %v", logs)
+	}
+	if seen := countLines(t, logs...); len(seen) != 6*150 {
+		t.Fatalf("got %d distinct lines across %d files, want %d", len(seen), len(logs), 6*150)
+	}
+}
+
+func TestRotationCompressAndKeep(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ansible.log")
+	writeConcurrently(t, path, rotation{size: 1 << 10, keep: 3, compress: true}, 1, 200)
+	files, err := filepath.Glob(path + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{path, path + ".1.gz", path + ".2.gz", path + ".3.gz", path + ".lock"}
+	if !slices.Equal(files, want) {
+		t.Fatalf("files %v, want %v", files, want)
+	}
+	// oldest first, the kept lines are the last ones written without a gap
+	var lines []string
+	for _, name := range []string{want[3], want[2], want[1]} {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		data, err := io.ReadAll(zr)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		lines = append(lines, strings.SplitAfter(string(data), "\n")...)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines = append(lines, strings.SplitAfter(string(data), "\n")...)
+	lines = slices.DeleteFunc(lines, func(l string) bool { return l == "" })
+	if len(lines) < 30 {
+		t.Fatalf("only %d lines kept", len(lines))
+	}
+	first := 200 - len(lines)
+	for i, line := range lines {
+		if want := fmt.Sprintf("writer=0 line=%d %s\n", first+i, strings.Repeat("x", 64)); line != want {
+			t.Fatalf("line %d is %q, want %q", i, line, want)
+		}
+	}
+}
+
+func TestRotationDue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ansible.log")
+	os.WriteFile(path, []byte("one line\n"), 0o644)
+	written := time.Date(2024, 5, 6, 23, 59, 0, 0, time.UTC)
+	os.Chtimes(path, written, written)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daily := rotation{every: 24 * time.Hour, keep: 1}
+	for _, tc := range []struct {
+		rot      rotation
+		incoming int
+		now      time.Time
+		due      bool
+	}{
+		{daily, 10, written.Add(30 * time.Second), false},
+		{daily, 10, written.Add(time.Minute), true},
+		{rotation{size: 20, keep: 1}, 11, written, false},
+		{rotation{size: 20, keep: 1}, 12, written, true},
+	} {
+		if got := tc.rot.due(info, tc.incoming, tc.now); got != tc.due {
+			t.Errorf("%+v, %d bytes at %s: due %t", tc.rot, tc.incoming, tc.now, got)
+		}
+	}
+	os.Truncate(path, 0)
+	if info, _ := os.Stat(path); daily.due(info, 10, written.Add(time.Hour)) {
+		t.Error("rotating an empty file")
+	}
+}
+
+func TestUnlockedAfterRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ansible.log")
+	s, err := openStorage(context.Background(), path, rotation{size: 16, keep: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, line := range []string{"first line that fills\n", "second rotates\n"} {
+		if _, err := s.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Fatalf("no rotation: %v", err)
+	}
+	other, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := syscall.Flock(int(other.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatalf("the fresh file is still locked after the write: %v", err)
+	}
+}
+
+func TestLockCancel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ansible.log")
+	other, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := syscall.Flock(int(other.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := openStorage(ctx, path, rotation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	done := make(chan error)
+	go func() {
+		_, err := s.Write([]byte("blocked\n"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("write went through a held lock: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cancelling did not end the wait")
+	}
+	if s.Err() == nil {
+		t.Fatal("failed write not recorded")
+	}
+}
+
+func TestBreakLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ansible.log")
+	stuck, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stuck.Close()
+	stuck.WriteString("before\n")
+	if err := syscall.Flock(int(stuck.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := openStorage(context.Background(), path, rotation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	done := make(chan error)
+	go func() {
+		_, err := s.Write([]byte("after\n"))
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := breakLock(path); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting writer did not move to the new file")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "before\nafter\n" {
+		t.Fatalf("got %q", data)
+	}
+}
+
+func TestHolderStale(t *testing.T) {
+	host, _ := os.Hostname()
+	now := time.Now()
+	alive := lockHolder{Pid: os.Getpid(), Host: host, Since: now.Add(-time.Minute)}
+	if reason := alive.stale(lockTimeout, now); reason != "" {
+		t.Fatalf("live holder reported stale: %s", reason)
+	}
+	if reason := alive.stale(30*time.Second, now); reason == "" {
+		t.Fatal("old holder not reported stale")
+	}
+	if reason := alive.stale(0, now.Add(time.Hour)); reason != "" {
+		t.Fatalf("age counted with --older-than 0: %s", reason)
+	}
+
+	child, err := os.StartProcess("/bin/true", []string{"true"}, &os.ProcAttr{})
+	if err != nil {
+		t.Skip(err)
+	}
+	child.Wait()
+	gone := lockHolder{Pid: child.Pid, Host: host, Since: now}
+	if reason := gone.stale(0, now); reason == "" {
+		t.Fatal("dead holder not reported stale")
+	}
+	elsewhere := lockHolder{Pid: child.Pid, Host: host + ".elsewhere", Since: now}
+	if reason := elsewhere.stale(0, now); reason != "" {
+		t.Fatalf("holder of another host reported stale: %s", reason)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pydpll/errorutils"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v3"
+)
+
+var runCmd = &cli.Command{
+	Name:      "run",
+	Usage:     "execute a command and log every line it prints, then its exit status and duration",
+	ArgsUsage: "-- COMMAND [ARGS...]",
+	Action:    wrap,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "stdout-level",
+			Usage: "`LEVEL` of the lines the command prints on stdout",
+			Value: "info",
+		},
+		&cli.StringFlag{
+			Name:  "stderr-level",
+			Usage: "`LEVEL` of the lines the command prints on stderr",
+			Value: "warn",
+		},
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Aliases: []string{"q"},
+			Usage:   "only log the output, do not pass it through to the terminal",
+		},
+	},
+}
+
+func wrap(ctx context.Context, cmd *cli.Command) (err error) {
+	args := cmd.Args().Slice()
+	if len(args) == 0 {
+		return errorutils.NewReport("nothing to run, usage: ansible run -- COMMAND [ARGS...]", "", errorutils.WithExitCode(2))
+	}
+	outLevel, err := parseLevel(cmd.String("stdout-level"))
+	if err != nil {
+		return errorutils.NewReport(err.Error(), "", errorutils.WithExitCode(2))
+	}
+	errLevel, err := parseLevel(cmd.String("stderr-level"))
+	if err != nil {
+		return errorutils.NewReport(err.Error(), "", errorutils.WithExitCode(2))
+	}
+	entry, file, err := openLog(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	commandLine := strings.Join(args, " ")
+
+	child := exec.Command(args[0], args[1:]...)
+	child.Stdin = os.Stdin
+	stdout, err := child.StdoutPipe()
+	errorutils.ExitOnFail(err)
+	stderr, err := child.StderrPipe()
+	errorutils.ExitOnFail(err)
+
+	// the child shares our process group and gets ^C and hangups from the terminal itself, ansible only stays around to record
+	// how it ended. Passing them on would deliver them twice, a second ^C is a force quit for many tools. SIGTERM is sent to us alone.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	start := time.Now()
+	entry.WithTime(start).Info("started: " + commandLine)
+	if err := child.Start(); err != nil {
+		entry.WithTime(time.Now()).WithField("exit_status", 127).Error("failed to start: " + commandLine + ": " + err.Error())
+		return errorutils.NewReport(err.Error(), "", errorutils.WithExitCode(127))
+	}
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGTERM {
+				child.Process.Signal(sig)
+			}
+		}
+	}()
+
+	var streams sync.WaitGroup
+	var passOut, passErr io.Writer = os.Stdout, os.Stderr
+	if cmd.Bool("quiet") {
+		passOut, passErr = io.Discard, io.Discard
+	}
+	streams.Add(2)
+	go logStream(&streams, stdout, passOut, entry, outLevel)
+	go logStream(&streams, stderr, passErr, entry, errLevel)
+	streams.Wait() // Wait closes the pipes, every line has to be read before
+	waitErr := child.Wait()
+	duration := time.Since(start)
+
+	code := exitCode(child.ProcessState)
+	status := child.ProcessState.String()
+	end := entry.WithTime(time.Now()).WithFields(logrus.Fields{"exit_status": code, "duration": duration.Round(time.Millisecond).String()})
+	if waitErr != nil {
+		end.Error("failed: " + commandLine + " (" + status + ")")
+	} else {
+		end.Info("finished: " + commandLine)
+	}
+	if file.Err() != nil {
+		return file.Err()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(waitErr, &exitErr) {
+		return cli.Exit("", code)
+	}
+	return waitErr
+}
+
+// exitCode follows the shell convention of 128+N for a child killed by signal N.
+func exitCode(state *os.ProcessState) int {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return state.ExitCode()
+}
+
+// logStream writes every line of r to the log with its own timestamp and copies it to pass.
+func logStream(wg *sync.WaitGroup, r io.Reader, pass io.Writer, entry *logrus.Entry, level logrus.Level) {
+	defer wg.Done()
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		line := s.Text()
+		pass.Write([]byte(line + "\n"))
+		if line == "" {
+			continue
+		}
+		entry.WithTime(time.Now()).Log(level, line)
+	}
+	if err := s.Err(); err != nil {
+		entry.WithTime(time.Now()).Warn("stopped reading output: " + err.Error())
+		io.Copy(pass, r) // keep draining so the child never blocks on a full pipe
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// jsonRecords reads the lines of a storage file written with --format json.
+func jsonRecords(t *testing.T, path string) []map[string]any {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []map[string]any
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var r map[string]any
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			t.Fatalf("%q: %v", s.Text(), err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestRunLogsChild(t *testing.T) {
+	path, err := runAnsible(t, "run", "-q", "--", "sh", "-c", "echo out; echo err >&2; exit 3")
+	var exit cli.ExitCoder
+	if !errors.As(err, &exit) || exit.ExitCode() != 3 {
+		t.Fatalf("run returned %v", err)
+	}
+	records := jsonRecords(t, path)
+	if len(records) != 4 {
+		t.Fatalf("%d lines: %v", len(records), records)
+	}
+	var stamps []time.Time
+	for _, r := range records {
+		stamp, err := time.Parse(time.RFC3339Nano, r["time"].(string))
+		if err != nil {
+			t.Fatalf("%v: %v", r, err)
+		}
+		stamps = append(stamps, stamp)
+	}
+	first, last := records[0], records[3]
+	if first["level"] != "info" || !strings.HasPrefix(first["msg"].(string), "started: sh -c") {
+		t.Errorf("first line %v", first)
+	}
+	// stdout and stderr are read side by side, their lines may land in either order
+	levels := map[string]any{records[1]["msg"].(string): records[1]["level"], records[2]["msg"].(string): records[2]["level"]}
+	if levels["out"] != "info" || levels["err"] != "warning" {
+		t.Errorf("output lines %v", records[1:3])
+	}
+	if last["level"] != "error" || !strings.HasPrefix(last["msg"].(string), "failed: sh -c") || last["exit_status"] != 3.0 || last["duration"] == nil {
+		t.Errorf("last line %v", last)
+	}
+	for i := 1; i < len(stamps); i++ {
+		if stamps[i].Before(stamps[0]) || stamps[3].Before(stamps[i]) {
+			t.Errorf("line %d at %v, outside of the run from %v to %v", i, stamps[i], stamps[0], stamps[3])
+		}
+	}
+}
+
+// TestRunForwardsOnlyTerm signals the test process the way a terminal and a service manager would. The child shares the
+// terminal's process group, so ansible must pass SIGTERM on and nothing else.
+func TestRunForwardsOnlyTerm(t *testing.T) {
+	script := `trap 'echo got INT' INT; trap 'echo got HUP' HUP; trap 'echo got TERM; exit 0' TERM; echo ready; while :; do sleep 0.05; done`
+	path := filepath.Join(t.TempDir(), "ansible.log")
+	done := make(chan error, 1)
+	go func() {
+		cmd := app
+		cmd.ExitErrHandler = func(context.Context, *cli.Command, error) {}
+		done <- cmd.Run(context.Background(), []string{"ansible", "--storage", path, "run", "-q", "--", "sh", "-c", script})
+	}()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if data, _ := os.ReadFile(path); strings.Contains(string(data), "] ready\n") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("child never started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	syscall.Kill(os.Getpid(), syscall.SIGINT)
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	time.Sleep(200 * time.Millisecond)
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("child still running after SIGTERM")
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "] got TERM\n") || strings.Contains(string(data), "] got INT\n") || strings.Contains(string(data), "] got HUP\n") {
+		t.Fatalf("storage holds %s", data)
+	}
+}
@@ -2,12 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"sort"
 	"time"
 
-	"github.com/go-mail/mail"
 	"github.com/pydpll/errorutils"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v3"
@@ -26,6 +26,7 @@ func main() {
 		Usage:    "usage",
 		Flags:    appFlags,
 		Commands: appCmds,
+		Action:   watchOrHelp,
 		Version:  fmt.Sprintf("%s%s (%s)", Version, Revision, CommitId),
 	}
 
@@ -49,14 +50,109 @@ var appFlags []cli.Flag = []cli.Flag{
 		Aliases: []string{"file", "a"},
 		Usage:   "The `DIR` to monitor",
 	},
+	&cli.DurationFlag{
+		Name:  "stable",
+		Usage: "wait until the file stopped growing for `DURATION`, e.g. the output of a finished job",
+	},
+	&cli.StringFlag{
+		Name:  "glob",
+		Usage: "wait for a file matching `PATTERN`",
+	},
+	&cli.StringFlag{
+		Name:  "contains",
+		Usage: "wait until a line of the file matches `REGEXP`, e.g. 'ERROR|Finished'",
+	},
+	&cli.IntFlag{
+		Name:  "entries",
+		Usage: "wait until the directory holds `N` entries",
+	},
+	&cli.IntFlag{
+		Name:  "pid",
+		Usage: "wait for process `PID` to exit",
+	},
+	&cli.StringFlag{
+		Name:  "subject",
+		Usage: "subject `TEMPLATE` (text/template), e.g. '{{.Job}} done on {{.Hostname}}'",
+	},
+	&cli.StringFlag{
+		Name:  "body",
+		Usage: "body `TEMPLATE` (text/template) with .Path .Size .ModTime .Hostname .Elapsed .Met .Tail .Checksum and the stamp, join, bytes, tail and sha256 functions",
+	},
+	&cli.StringFlag{
+		Name:  "body-file",
+		Usage: "read the body template from `FILE`",
+	},
+	&cli.StringFlag{
+		Name:  "html-file",
+		Usage: "html/template `FILE` for an HTML version of the email",
+	},
+	&cli.IntFlag{
+		Name:  "tail",
+		Usage: "include the last `N` lines of the file in the message",
+	},
+	&cli.BoolFlag{
+		Name:  "checksum",
+		Usage: "include the sha256 of the file in the message",
+	},
+	&cli.StringFlag{
+		Name:  "attach-max",
+		Usage: "attach the file itself to emails when it is at most `SIZE` (e.g. 512K, 2M)",
+	},
+	&cli.DurationFlag{
+		Name:  "poll",
+		Usage: "check the conditions every `INTERVAL` besides reacting to inotify events",
+		Value: 5 * time.Second,
+	},
+	&cli.BoolFlag{
+		Name:  "poll-only",
+		Usage: "do not use inotify, for network filesystems barker does not recognize",
+	},
 	&cli.StringFlag{
 		Name:    "recipient",
 		Aliases: []string{"r"},
 		Usage:   "email `ADDRESS` to report to",
 	},
+	&cli.StringFlag{
+		Name:  "config",
+		Usage: "YAML `FILE` choosing the notifiers, flags override it. default: $XDG_CONFIG_HOME/barker/config.yaml when present",
+	},
+	&cli.StringFlag{
+		Name:  "smtp-host",
+		Usage: "SMTP server `HOST`, smtp.gmail.com when only a recipient is given",
+	},
+	&cli.IntFlag{
+		Name:  "smtp-port",
+		Usage: "SMTP server `PORT`, 587 or 465 with --smtp-tls tls",
+	},
+	&cli.StringFlag{
+		Name:  "smtp-tls",
+		Usage: "SMTP encryption `MODE`: starttls, tls or none",
+	},
+	&cli.StringFlag{
+		Name:  "webhook",
+		Usage: "POST a JSON notification to `URL`",
+	},
+	&cli.BoolFlag{
+		Name:  "desktop",
+		Usage: "show a desktop notification over D-Bus",
+	},
+	&cli.StringFlag{
+		Name:  "hook",
+		Usage: "run `COMMAND` with sh, the message is in $BARKER_SUBJECT, $BARKER_BODY, $BARKER_PATH and on stdin",
+	},
+	&cli.IntFlag{
+		Name:  "retries",
+		Usage: "try a failing notifier `N` more times before leaving the message in the outbox for barker flush",
+		Value: 4,
+	},
+	&cli.DurationFlag{
+		Name:  "retry-wait",
+		Usage: "wait `DURATION` before the first retry, doubled after every failure up to 10m",
+		Value: 30 * time.Second,
+	},
 	&cli.BoolFlag{
 		Name:  "exp",
-		Usage: "Export commands to set the SMTP_SENDER and SMTP_PASSWORD environment variables",
+		Usage: "Export commands to set the SMTP_SENDER and SMTP_PASSWORD environment variables, password_cmd or secret in the config keep the password out of the environment",
 	},
 	&cli.IntFlag{
 		Name:        "walltime",
@@ -67,16 +163,26 @@ var appFlags []cli.Flag = []cli.Flag{
 }
 
 var appCmds []*cli.Command = []*cli.Command{
+	watchCmd,
+	statusCmd,
+	flushCmd,
 	{
 		Name:   "name",
 		Usage:  "Usage",
+		Hidden: true, // the single watch used to live here, kept for old scripts
 		Action: action1,
 	},
 }
 
+// watchOrHelp runs the single watch given by the flags, plain barker shows the help as it always did.
+func watchOrHelp(ctx context.Context, cmd *cli.Command) error {
+	if cmd.NumFlags() == 0 && cmd.Args().Len() == 0 {
+		return cli.ShowAppHelp(cmd)
+	}
+	return action1(ctx, cmd)
+}
+
 func action1(ctx context.Context, cmd *cli.Command) error {
-	file := cmd.String("dir")
-	recipient := cmd.String("recipient")
 	walltime := cmd.Int("walltime")
 	// when template is specified, print the template and exit
 	if cmd.Bool("exp") {
@@ -84,87 +190,158 @@ func action1(ctx context.Context, cmd *cli.Command) error {
 		fmt.Println("export SMTP_PASSWORD=\"\"")
 		os.Exit(0)
 	}
-	if file == "" {
+	job := watchJob{
+		Name: "barker",
+		conditionConfig: conditionConfig{
+			Path:     cmd.String("dir"),
+			Stable:   cmd.Duration("stable"),
+			Glob:     cmd.String("glob"),
+			Contains: cmd.String("contains"),
+			Entries:  int(cmd.Int("entries")),
+			Pid:      int(cmd.Int("pid")),
+		},
+		templateConfig: templateConfig{
+			Subject:   cmd.String("subject"),
+			Body:      cmd.String("body"),
+			BodyFile:  cmd.String("body-file"),
+			HTMLFile:  cmd.String("html-file"),
+			Tail:      int(cmd.Int("tail")),
+			Checksum:  cmd.Bool("checksum"),
+			AttachMax: cmd.String("attach-max"),
+		},
+		Walltime: time.Duration(walltime) * time.Hour,
+	}
+	if err := job.prepare(); err != nil {
 		errorutils.ExitOnFail(
-			errorutils.NewReport("BARKER: Please specify the directory or file to monitor.", ""),
+			errorutils.NewReport("BARKER: "+err.Error(), ""),
 			errorutils.WithExitCode(1),
 		)
 	}
-	if recipient == "" {
+	cfg, err := loadConfig(cmd.String("config"))
+	errorutils.ExitOnFail(err, errorutils.WithExitCode(1))
+	applyFlags(cmd, cfg)
+	notifiers, err := cfg.notifiers(ctx, nil)
+	errorutils.ExitOnFail(err)
+	if len(notifiers) == 0 {
 		errorutils.ExitOnFail(
-			errorutils.NewReport("BARKER: Please specify the email recipient.", ""),
+			errorutils.NewReport("BARKER: Please specify the email recipient or another notifier (--webhook, --desktop, --hook, --config).", ""),
 			errorutils.WithExitCode(1),
 		)
 	}
 
-	logrus.Info("BARKER: remember that the security of your password is your responsibility. To avoid text saves of your password, please avoid writing the export statement in `.bashrc` or `.bash_profile`. Additionally, HISTIGNORE can be used to avoid saving the command to your shell's history. e.g. `HISTIGNORE='*SMTP_PASSWORD*`")
-
 	if walltime < 1 || walltime > 168 {
 		errorutils.ExitOnFail(
 			errorutils.NewReport(fmt.Sprintf("BARKER: Please specify a walltime between 1 and 168 hours. You specified: %d", walltime), ""),
 			errorutils.WithExitCode(1),
 		)
 	}
-	// start timer
-	wt := time.NewTimer(time.Duration(walltime) * time.Hour)
 
-	// email setup
-	sender := os.Getenv("SMTP_SENDER")
-	password := os.Getenv("SMTP_PASSWORD")
+	reg, err := newRegistry("")
+	errorutils.WarnOnFail(err, errorutils.WithMsg("BARKER: barker status will not list this watch"))
+	defer reg.close()
+	err = job.run(ctx, notifiers, watchOptions{Poll: cmd.Duration("poll"), PollOnly: cmd.Bool("poll-only"), MaxErrors: 3}, retryFlags(cmd), reg)
+	if errors.Is(err, errWalltime) {
+		ranOutOfTime(int(walltime))
+	}
+	errorutils.ExitOnFail(err, errorutils.WithExitCode(3))
+	return nil
+}
 
-	if sender == "" || password == "" {
-		errorutils.WarnOnFail(
-			errorutils.NewReport("BARKER: Please specify the sender and password by setting environment variables `SMTP_SENDER` and `SMTP_PASSWORD`\nRemember that app passwords for gmail are needed. See https://support.google.com/accounts/answer/185833?hl=en", ""),
-			errorutils.WithExitCode(3),
-		)
-		os.Exit(3)
-	}
-	msg := mail.NewMessage()
-	msg.SetHeader("From", sender)
-	msg.SetHeader("To", recipient)
-	t := time.Now()
-	stringTime := t.Format("2006-01-02 15:04:05")
-	msg.SetHeader("Subject", fmt.Sprintf("Barker: change at %s", stringTime))
-	msg.SetBody("text/plain", fmt.Sprintf("%s\nBarker: your file %s has been created", stringTime, file))
-	d := mail.NewDialer("smtp.gmail.com", 587, sender, password)
+// applyFlags lets the notifier flags replace or add to the config file.
+func applyFlags(cmd *cli.Command, cfg *config) {
+	if cmd.String("recipient") != "" || cmd.IsSet("smtp-host") {
+		if cfg.SMTP == nil {
+			cfg.SMTP = &smtpConfig{Host: "smtp.gmail.com"}
+		}
+		if r := cmd.String("recipient"); r != "" {
+			cfg.SMTP.To = []string{r}
+		}
+		if h := cmd.String("smtp-host"); h != "" {
+			cfg.SMTP.Host = h
+		}
+	}
+	if cfg.SMTP != nil {
+		if cmd.IsSet("smtp-port") {
+			cfg.SMTP.Port = int(cmd.Int("smtp-port"))
+		}
+		if cmd.IsSet("smtp-tls") {
+			cfg.SMTP.TLS = cmd.String("smtp-tls")
+		}
+	}
+	if u := cmd.String("webhook"); u != "" {
+		cfg.Webhook = &webhookConfig{URL: u}
+	}
+	if cmd.Bool("desktop") {
+		cfg.Desktop = true
+	}
+	if c := cmd.String("hook"); c != "" {
+		cfg.Command = c
+	}
+}
 
-	// monitoring
-	var err2 error = os.ErrNotExist
-	var info os.FileInfo
-	tick := time.NewTicker(5 * time.Second)
-	var errCounter int // only warn 3 times for unexpected errors
-LOOP:
-	for {
-		select {
-		case <-wt.C:
-			ranOutOfTime(int(walltime))
-		case <-tick.C:
-			info, err2 = os.Stat(file)
-			if err2 == nil {
-				break LOOP
-			} else if os.IsNotExist(err2) {
-				continue
-			} else if errCounter < 3 {
-				errorutils.WarnOnFail(err2)
-				errCounter++
-				continue
-			} else if errCounter == 3 {
-				errorutils.ExitOnFail(err2, errorutils.WithExitCode(3))
+// notifiers builds the backends of cfg, recipients replace the addresses of the smtp section (gmail when there is none).
+// The smtp password is looked up once, watches of a job file share it.
+func (cfg *config) notifiers(ctx context.Context, recipients []string) ([]notifier, error) {
+	var notifiers []notifier
+	smtp := cfg.SMTP
+	if len(recipients) > 0 {
+		if smtp == nil {
+			smtp = &smtpConfig{Host: "smtp.gmail.com"}
+		}
+		withRecipients := *smtp
+		withRecipients.To = recipients
+		smtp = &withRecipients
+	}
+	if smtp != nil {
+		username := smtp.Username
+		if username == "" {
+			username = os.Getenv("SMTP_SENDER")
+		}
+		if username != "" && cfg.password == nil {
+			password, from, err := smtpPassword(ctx, *smtp)
+			if err != nil {
+				return nil, errorutils.NewReport("BARKER: "+err.Error(), "", errorutils.WithExitCode(3))
 			}
-
+			if password == "" {
+				return nil, errorutils.NewReport("BARKER: no password for "+username+". Set password_cmd, secret or password in the smtp section of the config, or the `SMTP_PASSWORD` environment variable\nRemember that app passwords for gmail are needed. See https://support.google.com/accounts/answer/185833?hl=en", "",
+					errorutils.WithExitCode(3),
+				)
+			}
+			if from == fromEnv {
+				logrus.Info("BARKER: remember that the security of your password is your responsibility. To avoid text saves of your password, please avoid writing the export statement in `.bashrc` or `.bash_profile`. Additionally, HISTIGNORE can be used to avoid saving the command to your shell's history. e.g. `HISTIGNORE='*SMTP_PASSWORD*`")
+			}
+			logrus.Debugf("BARKER: smtp password from %s", from)
+			cfg.password = &password
+		}
+		password := ""
+		if cfg.password != nil {
+			password = *cfg.password
 		}
+		withUser := *smtp
+		withUser.Username = username
+		n, err := newSMTPNotifier(withUser, password)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, n)
+	}
+	if cfg.Webhook != nil {
+		if cfg.Webhook.URL == "" {
+			return nil, errors.New("webhook: no url")
+		}
+		notifiers = append(notifiers, &webhookNotifier{URL: cfg.Webhook.URL, Headers: cfg.Webhook.Headers})
+	}
+	if cfg.Desktop {
+		notifiers = append(notifiers, desktopNotifier{})
+	}
+	if cfg.Command != "" {
+		notifiers = append(notifiers, &commandNotifier{Command: cfg.Command})
 	}
+	return notifiers, nil
+}
 
-	// attach info to email
-	temp, err := os.CreateTemp("", "barker.*.tmp")
-	errorutils.ExitOnFail(err)
-	_, err = temp.WriteString(fmt.Sprintf("Name: %s\nSize: %d\nMode: %s\nModTime: %s\nIsDir: %t\nSys: %v\n", info.Name(), info.Size(), info.Mode(), info.ModTime(), info.IsDir(), info.Sys()))
-	errorutils.ExitOnFail(err)
-	defer errorutils.NotifyClose(temp)
-	msg.Attach(temp.Name())
-	err = d.DialAndSend(msg)
-	errorutils.ExitOnFail(err)
-	return nil
+func retryFlags(cmd *cli.Command) retryPolicy {
+	return retryPolicy{Attempts: 1 + max(int(cmd.Int("retries")), 0), Wait: cmd.Duration("retry-wait"), MaxWait: 10 * time.Minute}
 }
 
 func ranOutOfTime(walltime int) {
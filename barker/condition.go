@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// condition is one thing barker waits for. check is called on every poll and reports whether it holds right now.
+type condition interface {
+	check(now time.Time) (bool, error)
+	// met is the sentence of the notification once the condition holds
+	met() string
+}
+
+// conditionConfig is how a watch describes what it waits for, every field set has to hold at the same time. A path alone waits for it to exist.
+type conditionConfig struct {
+	Path     string        `yaml:"path"`
+	Stable   time.Duration `yaml:"stable"`   // path exists and stopped changing for this long
+	Glob     string        `yaml:"glob"`     // some file matches the pattern
+	Contains string        `yaml:"contains"` // a line of path matches the regexp
+	Entries  int           `yaml:"entries"`  // directory path has at least this many entries
+	Pid      int           `yaml:"pid"`      // process exited
+}
+
+func (c conditionConfig) build() ([]condition, error) {
+	var conds []condition
+	needsPath := c.Stable > 0 || c.Contains != "" || c.Entries > 0
+	if needsPath && c.Path == "" {
+		return nil, errors.New("--stable, --contains and --entries need the path to watch")
+	}
+	if c.Path != "" && !needsPath {
+		conds = append(conds, &existsCond{path: c.Path})
+	}
+	if c.Stable > 0 {
+		conds = append(conds, &stableCond{path: c.Path, quiet: c.Stable})
+	}
+	if c.Contains != "" {
+		re, err := regexp.Compile(c.Contains)
+		if err != nil {
+			return nil, fmt.Errorf("--contains: %w", err)
+		}
+		conds = append(conds, &containsCond{path: c.Path, re: re})
+	}
+	if c.Entries > 0 {
+		conds = append(conds, &entriesCond{path: c.Path, n: c.Entries})
+	}
+	if c.Glob != "" {
+		if _, err := filepath.Match(c.Glob, ""); err != nil {
+			return nil, fmt.Errorf("--glob %q: %w", c.Glob, err)
+		}
+		conds = append(conds, &globCond{pattern: c.Glob})
+	}
+	if c.Pid > 0 {
+		conds = append(conds, &pidCond{pid: c.Pid})
+	}
+	if len(conds) == 0 {
+		return nil, errors.New("nothing to watch, please specify the directory or file to monitor, --glob or --pid")
+	}
+	return conds, nil
+}
+
+// subject is the path the notification is about, the first glob match when there is no path.
+func subject(conds []condition, path string) string {
+	if path != "" {
+		return path
+	}
+	for _, c := range conds {
+		if g, ok := c.(*globCond); ok && g.match != "" {
+			return g.match
+		}
+	}
+	return ""
+}
+
+// checkAll evaluates every condition, even after one fails, so stateful ones keep tracking the file.
+func checkAll(conds []condition, now time.Time) (bool, error) {
+	all := true
+	var errs []error
+	for _, c := range conds {
+		ok, err := c.check(now)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		all = all && ok
+	}
+	return all && len(errs) == 0, errors.Join(errs...)
+}
+
+type existsCond struct {
+	path string
+}
+
+func (c *existsCond) check(time.Time) (bool, error) {
+	return exists(c.path)
+}
+
+func (c *existsCond) met() string {
+	return fmt.Sprintf("your file %s has been created", c.path)
+}
+
+// exists hides the not-exist error, it is what barker waits out.
+func exists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return false, err
+}
+
+// stableCond holds once path kept the same size and mtime for quiet, like a job's output once the job is done.
+type stableCond struct {
+	path  string
+	quiet time.Duration
+	size  int64
+	mtime time.Time
+	since time.Time // first poll seeing the current size and mtime
+}
+
+func (c *stableCond) check(now time.Time) (bool, error) {
+	info, err := os.Stat(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		c.since = time.Time{}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if c.since.IsZero() || info.Size() != c.size || !info.ModTime().Equal(c.mtime) {
+		c.size, c.mtime, c.since = info.Size(), info.ModTime(), now
+		return false, nil
+	}
+	return now.Sub(c.since) >= c.quiet, nil
+}
+
+func (c *stableCond) met() string {
+	return fmt.Sprintf("%s stopped growing for %s", c.path, c.quiet)
+}
+
+// containsCond reads path as it grows and holds once a line matches. Lines are matched one by one, a pattern cannot span them.
+type containsCond struct {
+	path    string
+	re      *regexp.Regexp
+	offset  int64
+	partial []byte // unterminated last line, matched again once complete
+	line    string // the matching line
+}
+
+const maxLine = 1 << 20
+
+func (c *containsCond) check(time.Time) (bool, error) {
+	if c.line != "" {
+		return true, nil
+	}
+	f, err := os.Open(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	if info.Size() < c.offset { // truncated or replaced
+		c.offset, c.partial = 0, nil
+	}
+	if _, err := f.Seek(c.offset, io.SeekStart); err != nil {
+		return false, err
+	}
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := f.Read(buf)
+		c.offset += int64(n)
+		if c.scan(buf[:n]) {
+			return true, nil
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	if c.re.Match(c.partial) {
+		c.line = string(c.partial)
+		return true, nil
+	}
+	return false, nil
+}
+
+// scan matches the lines completed by chunk and keeps the rest for the next one.
+func (c *containsCond) scan(chunk []byte) bool {
+	data := append(c.partial, chunk...)
+	for {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			break
+		}
+		if c.re.Match(data[:end]) {
+			c.line = string(data[:end])
+			return true
+		}
+		data = data[end+1:]
+	}
+	if len(data) > maxLine {
+		data = data[len(data)-maxLine:]
+	}
+	c.partial = append(c.partial[:0:0], data...)
+	return false
+}
+
+func (c *containsCond) met() string {
+	return fmt.Sprintf("%s contains %q", c.path, strings.TrimSpace(c.line))
+}
+
+type entriesCond struct {
+	path  string
+	n     int
+	count int
+}
+
+func (c *entriesCond) check(time.Time) (bool, error) {
+	f, err := os.Open(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return false, err
+	}
+	c.count = len(names)
+	return c.count >= c.n, nil
+}
+
+func (c *entriesCond) met() string {
+	return fmt.Sprintf("%s has %d entries", c.path, c.count)
+}
+
+type globCond struct {
+	pattern string
+	match   string
+}
+
+func (c *globCond) check(time.Time) (bool, error) {
+	matches, err := filepath.Glob(c.pattern)
+	if err != nil || len(matches) == 0 {
+		return false, err
+	}
+	c.match = matches[0]
+	return true, nil
+}
+
+func (c *globCond) met() string {
+	return fmt.Sprintf("%s matches %s", c.match, c.pattern)
+}
+
+type pidCond struct {
+	pid int
+}
+
+func (c *pidCond) check(time.Time) (bool, error) {
+	return !running(c.pid), nil
+}
+
+func (c *pidCond) met() string {
+	return fmt.Sprintf("process %d exited", c.pid)
+}
+
+// running counts a zombie as gone, its parent just did not reap it yet.
+func running(pid int) bool {
+	if err := syscall.Kill(pid, 0); err == syscall.ESRCH {
+		return false
+	}
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return true
+	}
+	// the state follows the parenthesized command name, which may itself hold spaces and parentheses
+	if i := bytes.LastIndexByte(stat, ')'); i >= 0 && i+2 < len(stat) {
+		return stat[i+2] != 'Z'
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustBuild(t *testing.T, cfg conditionConfig) []condition {
+	t.Helper()
+	conds, err := cfg.build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conds
+}
+
+func mustCheck(t *testing.T, conds []condition, now time.Time) bool {
+	t.Helper()
+	ok, err := checkAll(conds, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+func TestStableCondition(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.txt")
+	conds := mustBuild(t, conditionConfig{Path: path, Stable: 10 * time.Second})
+	start := time.Now()
+	if mustCheck(t, conds, start) {
+		t.Fatal("missing file is stable")
+	}
+	os.WriteFile(path, []byte("a"), 0o644)
+	if mustCheck(t, conds, start.Add(time.Second)) || mustCheck(t, conds, start.Add(5*time.Second)) {
+		t.Fatal("stable before the quiet period")
+	}
+	os.WriteFile(path, []byte("ab"), 0o644)
+	if mustCheck(t, conds, start.Add(12*time.Second)) {
+		t.Fatal("growth did not restart the quiet period")
+	}
+	if !mustCheck(t, conds, start.Add(22*time.Second)) {
+		t.Fatal("not stable after the quiet period")
+	}
+}
+
+func TestContainsCondition(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.log")
+	conds := mustBuild(t, conditionConfig{Path: path, Contains: `^Finished`})
+	now := time.Now()
+	if mustCheck(t, conds, now) {
+		t.Fatal("missing file matched")
+	}
+	f, _ := os.Create(path)
+	defer f.Close()
+	f.WriteString("step 1\nstep 2\nNot Finished\nFini")
+	if mustCheck(t, conds, now) {
+		t.Fatal("matched too early")
+	}
+	f.WriteString("shed in 3h\n")
+	if !mustCheck(t, conds, now) {
+		t.Fatal("line completed across polls not matched")
+	}
+	if got := conds[0].met(); !strings.Contains(got, "Finished in 3h") {
+		t.Fatalf("met() = %q", got)
+	}
+}
+
+func TestEntriesAndGlobConditions(t *testing.T) {
+	dir := t.TempDir()
+	conds := mustBuild(t, conditionConfig{Path: dir, Entries: 2, Glob: filepath.Join(dir, "*.bam")})
+	now := time.Now()
+	os.WriteFile(filepath.Join(dir, "a.txt"), nil, 0o644)
+	os.WriteFile(filepath.Join(dir, "b.txt"), nil, 0o644)
+	if mustCheck(t, conds, now) {
+		t.Fatal("held without a glob match")
+	}
+	os.WriteFile(filepath.Join(dir, "c.bam"), nil, 0o644)
+	if !mustCheck(t, conds, now) {
+		t.Fatal("entries and glob not met")
+	}
+	if got := subject(mustBuild(t, conditionConfig{Glob: filepath.Join(dir, "*.bam")}), ""); got != "" {
+		t.Fatalf("subject before a check = %q", got)
+	}
+}
+
+func TestPidCondition(t *testing.T) {
+	child := exec.Command("sleep", "0.2")
+	if err := child.Start(); err != nil {
+		t.Skip(err)
+	}
+	conds := mustBuild(t, conditionConfig{Pid: child.Process.Pid})
+	if mustCheck(t, conds, time.Now()) {
+		t.Fatal("running process reported exited")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !mustCheck(t, conds, time.Now()) { // not reaped yet, a zombie counts as exited
+		if time.Now().After(deadline) {
+			t.Fatal("exit not noticed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	child.Wait()
+}
+
+func TestConditionConfig(t *testing.T) {
+	for _, cfg := range []conditionConfig{
+		{},
+		{Stable: time.Second},
+		{Path: "x", Contains: "("},
+		{Glob: "["},
+	} {
+		if _, err := cfg.build(); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+}
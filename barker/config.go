@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// config is the optional YAML file choosing the notifiers, flags given on the command line win over it.
+//
+//	smtp:
+//	  host: smtp.example.org
+//	  port: 465
+//	  tls: tls
+//	  username: me@example.org
+//	  password_cmd: pass show smtp
+//	  to: [me@example.org]
+//	webhook:
+//	  url: https://hooks.example.org/barker
+//	desktop: true
+//	command: notify-me "$BARKER_SUBJECT"
+type config struct {
+	SMTP    *smtpConfig    `yaml:"smtp"`
+	Webhook *webhookConfig `yaml:"webhook"`
+	Desktop bool           `yaml:"desktop"`
+	Command string         `yaml:"command"`
+
+	password *string // looked up by notifiers
+}
+
+type smtpConfig struct {
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"`
+	TLS      string   `yaml:"tls"` // starttls, tls or none
+	Username string   `yaml:"username"`
+	From     string   `yaml:"from"` // defaults to the username
+	To       []string `yaml:"to"`
+
+	// the password comes from the first of these given, see smtpPassword
+	PasswordCmd string            `yaml:"password_cmd"`
+	Secret      map[string]string `yaml:"secret"`   // Secret Service item attributes
+	Password    string            `yaml:"password"` // only in a file others cannot read
+}
+
+type webhookConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// defaultConfigPath is only read when it exists.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "barker", "config.yaml")
+}
+
+// loadConfig reads path, an empty path falls back to the default location and a missing default file is an empty config.
+func loadConfig(path string) (*config, error) {
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	cfg := &config{}
+	if path == "" {
+		return cfg, nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	if cfg.SMTP != nil && cfg.SMTP.Password != "" {
+		if err := checkPermissions(path, info); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	passwordCmdTimeout = 2 * time.Minute
+	unlockTimeout      = 2 * time.Minute
+)
+
+// credential sources, in the order smtpPassword tries them.
+const (
+	fromCommand = "password_cmd"
+	fromSecret  = "secret service"
+	fromConfig  = "config file"
+	fromEnv     = "SMTP_PASSWORD"
+)
+
+// smtpPassword finds the password of the smtp section: the output of password_cmd, then the Secret Service item
+// matching the secret attributes, then the password of the config file and last the SMTP_PASSWORD variable.
+// It also says where the password came from, an empty password with no error means there is none.
+func smtpPassword(ctx context.Context, cfg smtpConfig) (string, string, error) {
+	switch {
+	case cfg.PasswordCmd != "":
+		pw, err := passwordFromCommand(ctx, cfg.PasswordCmd)
+		return pw, fromCommand, err
+	case len(cfg.Secret) > 0:
+		pw, err := passwordFromSecretService(ctx, cfg.Secret)
+		return pw, fromSecret, err
+	case cfg.Password != "":
+		return cfg.Password, fromConfig, nil
+	}
+	return os.Getenv("SMTP_PASSWORD"), fromEnv, nil
+}
+
+// passwordFromCommand runs command with sh and keeps the first line it prints, like `pass show` does it.
+// The terminal stays connected so gpg or a keyring can ask for their own passphrase.
+func passwordFromCommand(ctx context.Context, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, passwordCmdTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin, cmd.Stderr = os.Stdin, os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("password_cmd %q: %w", command, err)
+	}
+	pw, _, _ := strings.Cut(string(out), "\n")
+	pw = strings.TrimSuffix(pw, "\r")
+	if pw == "" {
+		return "", fmt.Errorf("password_cmd %q printed no password", command)
+	}
+	return pw, nil
+}
+
+// secret is the Secret Service (oayays) struct.
+type secret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// passwordFromSecretService looks up the item with the given attributes in the Secret Service (gnome-keyring, KeePassXC,
+// kwallet), what `secret-tool lookup` does. Items stored with `secret-tool store --label=barker service barker username me@example.org`
+// match the attributes {service: barker, username: me@example.org}.
+func passwordFromSecretService(ctx context.Context, attributes map[string]string) (string, error) {
+	conn, err := dbus.ConnectSessionBus(dbus.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("secret service: %w", err)
+	}
+	defer conn.Close()
+	service := conn.Object("org.freedesktop.secrets", "/org/freedesktop/secrets")
+
+	var ignored dbus.Variant
+	var session dbus.ObjectPath
+	if err := service.CallWithContext(ctx, "org.freedesktop.Secret.Service.OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&ignored, &session); err != nil {
+		return "", fmt.Errorf("secret service: %w", err)
+	}
+	defer conn.Object("org.freedesktop.secrets", session).CallWithContext(ctx, "org.freedesktop.Secret.Session.Close", 0)
+
+	var unlocked, locked []dbus.ObjectPath
+	if err := service.CallWithContext(ctx, "org.freedesktop.Secret.Service.SearchItems", 0, attributes).Store(&unlocked, &locked); err != nil {
+		return "", fmt.Errorf("secret service: %w", err)
+	}
+	if len(unlocked) == 0 && len(locked) > 0 {
+		var prompt dbus.ObjectPath
+		if err := service.CallWithContext(ctx, "org.freedesktop.Secret.Service.Unlock", 0, locked).Store(&unlocked, &prompt); err != nil {
+			return "", fmt.Errorf("secret service: %w", err)
+		}
+		if len(unlocked) == 0 && prompt != "/" {
+			if unlocked, err = runPrompt(ctx, conn, prompt); err != nil {
+				return "", fmt.Errorf("secret service: %w", err)
+			}
+		}
+		if len(unlocked) == 0 {
+			return "", errors.New("secret service: the keyring holding the password is locked, unlock it and start barker again")
+		}
+	}
+	if len(unlocked) == 0 {
+		return "", fmt.Errorf("secret service: no item with attributes %v", attributes)
+	}
+	var s secret
+	if err := conn.Object("org.freedesktop.secrets", unlocked[0]).CallWithContext(ctx, "org.freedesktop.Secret.Item.GetSecret", 0, session).Store(&s); err != nil {
+		return "", fmt.Errorf("secret service: %w", err)
+	}
+	pw := string(bytes.TrimRight(s.Value, "\n"))
+	if pw == "" {
+		return "", fmt.Errorf("secret service: the item with attributes %v is empty", attributes)
+	}
+	return pw, nil
+}
+
+// runPrompt asks the keyring to show its unlock dialog and returns what the user unlocked, nothing if they dismissed it.
+func runPrompt(ctx context.Context, conn *dbus.Conn, prompt dbus.ObjectPath) ([]dbus.ObjectPath, error) {
+	ctx, cancel := context.WithTimeout(ctx, unlockTimeout)
+	defer cancel()
+	match := []dbus.MatchOption{dbus.WithMatchObjectPath(prompt), dbus.WithMatchInterface("org.freedesktop.Secret.Prompt"), dbus.WithMatchMember("Completed")}
+	if err := conn.AddMatchSignalContext(ctx, match...); err != nil {
+		return nil, err
+	}
+	defer conn.RemoveMatchSignalContext(context.Background(), match...)
+	signals := make(chan *dbus.Signal, 4)
+	conn.Signal(signals)
+	defer conn.RemoveSignal(signals)
+
+	if err := conn.Object("org.freedesktop.secrets", prompt).CallWithContext(ctx, "org.freedesktop.Secret.Prompt.Prompt", 0, "").Err; err != nil {
+		return nil, err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("nobody unlocked the keyring within %s", unlockTimeout)
+		case sig := <-signals:
+			if sig.Path != prompt || sig.Name != "org.freedesktop.Secret.Prompt.Completed" || len(sig.Body) != 2 {
+				continue
+			}
+			if dismissed, _ := sig.Body[0].(bool); dismissed {
+				return nil, nil
+			}
+			result, _ := sig.Body[1].(dbus.Variant)
+			unlocked, _ := result.Value().([]dbus.ObjectPath)
+			return unlocked, nil
+		}
+	}
+}
+
+// checkPermissions refuses a file holding a password anyone but its owner can read, as ssh does with keys.
+func checkPermissions(path string, info os.FileInfo) error {
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		return fmt.Errorf("config %s holds a password and is open to others (%v), run chmod 600 %s", path, perm, path)
+	}
+	return nil
+}
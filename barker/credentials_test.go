@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+func TestSMTPPasswordPrecedence(t *testing.T) {
+	t.Setenv("SMTP_PASSWORD", "from-env")
+	ctx := context.Background()
+	for _, tc := range []struct {
+		cfg      smtpConfig
+		password string
+		from     string
+	}{
+		{smtpConfig{PasswordCmd: "printf 'from-cmd\\nsecond line'", Password: "from-config"}, "from-cmd", fromCommand},
+		{smtpConfig{Password: "from-config"}, "from-config", fromConfig},
+		{smtpConfig{}, "from-env", fromEnv},
+	} {
+		pw, from, err := smtpPassword(ctx, tc.cfg)
+		if err != nil || pw != tc.password || from != tc.from {
+			t.Errorf("%+v: got %q from %s, %v", tc.cfg, pw, from, err)
+		}
+	}
+	for _, command := range []string{"exit 1", "true"} {
+		if _, _, err := smtpPassword(ctx, smtpConfig{PasswordCmd: command}); err == nil {
+			t.Errorf("password_cmd %q accepted", command)
+		}
+	}
+}
+
+func TestConfigWithPasswordPermissions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("smtp:\n  host: mail\n  username: me\n  password: hunter2\n"), 0o644)
+	if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), "chmod 600") {
+		t.Fatalf("world readable config with a password: %v", err)
+	}
+	os.Chmod(path, 0o640)
+	if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), "chmod 600") {
+		t.Fatalf("group readable config with a password: %v", err)
+	}
+	os.Chmod(path, 0o600)
+	cfg, err := loadConfig(path)
+	if err != nil || cfg.SMTP.Password != "hunter2" {
+		t.Fatalf("got %+v, %v", cfg, err)
+	}
+	os.WriteFile(path, []byte("smtp:\n  host: mail\n  password_cmd: pass show smtp\n"), 0o644)
+	os.Chmod(path, 0o644)
+	if _, err := loadConfig(path); err != nil {
+		t.Fatalf("config without a password refused: %v", err)
+	}
+}
+
+// fakeSecrets serves one item the way gnome-keyring does, unlocking it through a prompt the user accepts or dismisses.
+type fakeSecrets struct {
+	conn       *dbus.Conn
+	attributes map[string]string
+	mu         sync.Mutex // dbus calls come from the connection's goroutine
+	locked     bool
+	dismiss    bool
+}
+
+func (s *fakeSecrets) set(locked, dismiss bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.locked, s.dismiss = locked, dismiss
+}
+
+const fakeItem = dbus.ObjectPath("/org/freedesktop/secrets/collection/login/1")
+
+func (s *fakeSecrets) OpenSession(algorithm string, input dbus.Variant) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+	if algorithm != "plain" {
+		return dbus.Variant{}, "", dbus.MakeFailedError(os.ErrInvalid)
+	}
+	return dbus.MakeVariant(""), "/org/freedesktop/secrets/session/1", nil
+}
+
+func (s *fakeSecrets) SearchItems(attributes map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, *dbus.Error) {
+	for k, v := range attributes {
+		if s.attributes[k] != v {
+			return []dbus.ObjectPath{}, []dbus.ObjectPath{}, nil
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locked {
+		return []dbus.ObjectPath{}, []dbus.ObjectPath{fakeItem}, nil
+	}
+	return []dbus.ObjectPath{fakeItem}, []dbus.ObjectPath{}, nil
+}
+
+const fakePrompt = dbus.ObjectPath("/org/freedesktop/secrets/prompt/1")
+
+func (s *fakeSecrets) Unlock(objects []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	return []dbus.ObjectPath{}, fakePrompt, nil
+}
+
+func (s *fakeSecrets) Prompt(windowID string) *dbus.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlocked := []dbus.ObjectPath{}
+	if !s.dismiss {
+		s.locked = false
+		unlocked = append(unlocked, fakeItem)
+	}
+	go s.conn.Emit(fakePrompt, "org.freedesktop.Secret.Prompt.Completed", s.dismiss, dbus.MakeVariant(unlocked))
+	return nil
+}
+
+func (s *fakeSecrets) GetSecret(session dbus.ObjectPath) (secret, *dbus.Error) {
+	return secret{Session: session, Value: []byte("from-keyring"), ContentType: "text/plain"}, nil
+}
+
+func (s *fakeSecrets) Close() *dbus.Error {
+	return nil
+}
+
+// privateBus starts a session bus for the test, there is none in CI.
+func privateBus(t *testing.T) string {
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("no dbus-daemon")
+	}
+	cmd := exec.Command(daemon, "--session", "--nofork", "--print-address")
+	stdout, _ := cmd.StdoutPipe()
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill(); cmd.Wait() })
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Skip("dbus-daemon printed no address")
+	}
+	return strings.TrimSpace(address)
+}
+
+func TestPasswordFromSecretService(t *testing.T) {
+	address := privateBus(t)
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", address)
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fake := &fakeSecrets{conn: conn, attributes: map[string]string{"service": "barker", "username": "me@example.org"}}
+	conn.Export(fake, "/org/freedesktop/secrets", "org.freedesktop.Secret.Service")
+	conn.Export(fake, fakeItem, "org.freedesktop.Secret.Item")
+	conn.Export(fake, "/org/freedesktop/secrets/session/1", "org.freedesktop.Secret.Session")
+	conn.Export(fake, fakePrompt, "org.freedesktop.Secret.Prompt")
+	if reply, err := conn.RequestName("org.freedesktop.secrets", dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatal("cannot own org.freedesktop.secrets", err)
+	}
+
+	ctx := context.Background()
+	pw, from, err := smtpPassword(ctx, smtpConfig{Secret: map[string]string{"service": "barker"}, Password: "from-config"})
+	if err != nil || pw != "from-keyring" || from != fromSecret {
+		t.Fatalf("got %q from %s, %v", pw, from, err)
+	}
+	if _, err := passwordFromSecretService(ctx, map[string]string{"service": "other"}); err == nil || !strings.Contains(err.Error(), "no item") {
+		t.Fatalf("missing item: %v", err)
+	}
+	fake.set(true, true)
+	if _, err := passwordFromSecretService(ctx, map[string]string{"service": "barker"}); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Fatalf("unlock dismissed: %v", err)
+	}
+	fake.set(true, false)
+	if pw, err := passwordFromSecretService(ctx, map[string]string{"service": "barker"}); err != nil || pw != "from-keyring" {
+		t.Fatalf("unlocked through the prompt: %q, %v", pw, err)
+	}
+}
@@ -5,10 +5,13 @@ go 1.24
 toolchain go1.24.0
 
 require (
+	github.com/fsnotify/fsnotify v1.8.0
 	github.com/go-mail/mail v2.3.1+incompatible
+	github.com/godbus/dbus/v5 v5.2.2
 	github.com/pydpll/errorutils v0.2.1-0.20250330233827-f8d5de79edae
 	github.com/sirupsen/logrus v1.9.3
 	github.com/urfave/cli/v3 v3.0.0-beta1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
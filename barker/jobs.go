@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pydpll/errorutils"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// maxWalltime keeps the promise that barker never runs forever.
+const maxWalltime = 168 * time.Hour
+
+var errWalltime = errors.New("walltime ran out")
+
+var watchCmd = &cli.Command{
+	Name:  "watch",
+	Usage: "run every watch of a job file at once",
+	Description: "each job has the conditions of the single watch flags (path, stable, glob, contains, entries, pid), its own recipients, walltime\n" +
+		"and message (subject, body, body_file, html_file, tail, checksum, attach_max):\n\n" +
+		"  jobs:\n" +
+		"    - name: alignment\n" +
+		"      path: /scratch/run42/sample.bam\n" +
+		"      stable: 5m\n" +
+		"      recipients: [me@example.org]\n" +
+		"      walltime: 48h\n" +
+		"    - name: pipeline\n" +
+		"      pid: 4242\n" +
+		"      subject: pipeline is over\n\n" +
+		"the notifiers come from the config file and flags, recipients replace the email addresses.",
+	Action: watchJobs,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "jobs",
+			Usage:    "YAML `FILE` listing the watches",
+			Required: true,
+		},
+	},
+}
+
+var statusCmd = &cli.Command{
+	Name:   "status",
+	Usage:  "list the watches of every running barker",
+	Action: status,
+}
+
+// watchJob is one watch of a job file, the single watch flags build one too.
+type watchJob struct {
+	Name            string `yaml:"name"`
+	conditionConfig `yaml:",inline"`
+	templateConfig  `yaml:",inline"`
+	Recipients      []string      `yaml:"recipients"`
+	Walltime        time.Duration `yaml:"walltime"`
+
+	conds []condition
+	tmpl  *messageTemplates
+}
+
+// prepare builds the conditions and templates, every mistake in a job file shows up before any wait starts.
+func (job *watchJob) prepare() error {
+	var err error
+	if job.conds, err = job.build(); err != nil {
+		return err
+	}
+	if job.tmpl, err = job.compile(); err != nil {
+		return fmt.Errorf("template: %w", err)
+	}
+	return nil
+}
+
+type jobsFile struct {
+	Jobs []watchJob `yaml:"jobs"`
+}
+
+func loadJobs(path string) ([]watchJob, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file jobsFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("jobs %s: %w", path, err)
+	}
+	if len(file.Jobs) == 0 {
+		return nil, fmt.Errorf("jobs %s: no jobs", path)
+	}
+	names := make(map[string]bool)
+	for i := range file.Jobs {
+		job := &file.Jobs[i]
+		if job.Name == "" {
+			job.Name = "job" + strconv.Itoa(i+1)
+		}
+		if names[job.Name] {
+			return nil, fmt.Errorf("jobs %s: two jobs named %s", path, job.Name)
+		}
+		names[job.Name] = true
+		if job.Walltime == 0 {
+			job.Walltime = 24 * time.Hour
+		}
+		if job.Walltime < 0 || job.Walltime > maxWalltime {
+			return nil, fmt.Errorf("jobs %s: %s: walltime %s is not between 0 and %s", path, job.Name, job.Walltime, maxWalltime)
+		}
+	}
+	return file.Jobs, nil
+}
+
+// describe is the condition as barker status shows it.
+func (job *watchJob) describe() string {
+	var parts []string
+	c := job.conditionConfig
+	if c.Path != "" {
+		parts = append(parts, c.Path)
+	}
+	if c.Stable > 0 {
+		parts = append(parts, "stable "+c.Stable.String())
+	}
+	if c.Contains != "" {
+		parts = append(parts, "contains /"+c.Contains+"/")
+	}
+	if c.Entries > 0 {
+		parts = append(parts, "entries >= "+strconv.Itoa(c.Entries))
+	}
+	if c.Glob != "" {
+		parts = append(parts, "glob "+c.Glob)
+	}
+	if c.Pid > 0 {
+		parts = append(parts, "pid "+strconv.Itoa(c.Pid))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// run waits for the job's conditions and notifies, retrying with the policy. Its progress is kept in reg for barker status.
+func (job *watchJob) run(ctx context.Context, notifiers []notifier, opts watchOptions, retry retryPolicy, reg *registry) error {
+	started := time.Now()
+	wctx, cancel := context.WithTimeout(ctx, job.Walltime)
+	defer cancel()
+	reg.set(job, "waiting", "")
+	err := waitFor(wctx, job.conds, opts)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		reg.set(job, "timed out", "")
+		return fmt.Errorf("%s: %w after %s", job.Name, errWalltime, job.Walltime)
+	case errors.Is(err, context.Canceled):
+		reg.set(job, "stopped", "")
+		return err
+	case err != nil:
+		reg.set(job, "failed", err.Error())
+		return fmt.Errorf("%s: %w", job.Name, err)
+	}
+	msg, err := job.tmpl.render(job.Name, job.conds, subject(job.conds, job.Path), started, time.Now())
+	if err != nil {
+		reg.set(job, "failed", err.Error())
+		return fmt.Errorf("%s: template: %w", job.Name, err)
+	}
+	reg.set(job, "notifying", msg.Subject)
+	// the walltime is over the wait, a slow notification still gets its retries
+	if err := deliver(ctx, job.Name, notifiers, msg, retry); err != nil {
+		reg.set(job, "failed", err.Error())
+		return fmt.Errorf("%s: %w", job.Name, err)
+	}
+	reg.set(job, "notified", msg.Subject)
+	return nil
+}
+
+func watchJobs(ctx context.Context, cmd *cli.Command) error {
+	jobs, err := loadJobs(cmd.String("jobs"))
+	if err != nil {
+		return errorutils.NewReport("BARKER: "+err.Error(), "", errorutils.WithExitCode(1))
+	}
+	cfg, err := loadConfig(cmd.String("config"))
+	if err != nil {
+		return errorutils.NewReport("BARKER: "+err.Error(), "", errorutils.WithExitCode(1))
+	}
+	applyFlags(cmd, cfg)
+	notifiers := make([][]notifier, len(jobs))
+	for i := range jobs {
+		if err := jobs[i].prepare(); err != nil {
+			return errorutils.NewReport("BARKER: "+jobs[i].Name+": "+err.Error(), "", errorutils.WithExitCode(1))
+		}
+		if notifiers[i], err = cfg.notifiers(ctx, jobs[i].Recipients); err != nil {
+			return err
+		}
+		if len(notifiers[i]) == 0 {
+			return errorutils.NewReport("BARKER: "+jobs[i].Name+": no recipients and no notifier configured", "", errorutils.WithExitCode(1))
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	reg, err := newRegistry(cmd.String("jobs"))
+	errorutils.WarnOnFail(err, errorutils.WithMsg("BARKER: barker status will not list these watches"))
+	defer reg.close()
+
+	for i := range jobs {
+		reg.set(&jobs[i], "waiting", "") // listed in file order
+	}
+	opts := watchOptions{Poll: cmd.Duration("poll"), PollOnly: cmd.Bool("poll-only"), MaxErrors: 3}
+	retry := retryFlags(cmd)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failed   int
+		timedOut int
+	)
+	for i := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := jobs[i].run(ctx, notifiers[i], opts, retry, reg)
+			if err == nil {
+				logrus.Info("BARKER: " + jobs[i].Name + " notified")
+				return
+			}
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			errorutils.WarnOnFail(err)
+			mu.Lock()
+			defer mu.Unlock()
+			if errors.Is(err, errWalltime) {
+				timedOut++
+			} else {
+				failed++
+			}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return errorutils.NewReport("BARKER: interrupted, pending watches were dropped", "", errorutils.WithExitCode(130))
+	}
+	return jobsOutcome(failed, timedOut, len(jobs))
+}
+
+// jobsOutcome exits 3 when a job failed, 4 when jobs only ran out of walltime.
+func jobsOutcome(failed, timedOut, total int) error {
+	switch {
+	case failed > 0 && timedOut > 0:
+		return errorutils.NewReport(fmt.Sprintf("BARKER: %d failed, %d ran out of walltime of %d jobs", failed, timedOut, total), "", errorutils.WithExitCode(3))
+	case failed > 0:
+		return errorutils.NewReport(fmt.Sprintf("BARKER: %d of %d jobs failed", failed, total), "", errorutils.WithExitCode(3))
+	case timedOut > 0:
+		return errorutils.NewReport(fmt.Sprintf("BARKER: %d of %d jobs ran out of walltime", timedOut, total), "", errorutils.WithExitCode(4))
+	}
+	return nil
+}
+
+// registry is the state file of a running barker, one per process in the status directory.
+type registry struct {
+	mu    sync.Mutex
+	path  string
+	state processState
+}
+
+type processState struct {
+	Pid      int         `json:"pid"`
+	Started  time.Time   `json:"started"`
+	JobsFile string      `json:"jobs_file,omitempty"`
+	Jobs     []jobStatus `json:"jobs"`
+}
+
+type jobStatus struct {
+	Name     string    `json:"name"`
+	Watch    string    `json:"watch"`
+	Deadline time.Time `json:"deadline"`
+	State    string    `json:"state"`
+	Detail   string    `json:"detail,omitempty"`
+	Updated  time.Time `json:"updated"`
+}
+
+// statusDir is private to the user, under XDG_RUNTIME_DIR when there is one.
+func statusDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "barker")
+	}
+	return filepath.Join(os.TempDir(), "barker-"+strconv.Itoa(os.Getuid()))
+}
+
+// newRegistry always returns a usable registry, without a state file when it could not be created.
+func newRegistry(jobsFile string) (*registry, error) {
+	reg := &registry{state: processState{Pid: os.Getpid(), Started: time.Now(), JobsFile: jobsFile}}
+	if jobsFile != "" {
+		if abs, err := filepath.Abs(jobsFile); err == nil {
+			reg.state.JobsFile = abs
+		}
+	}
+	dir := statusDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return reg, err
+	}
+	reg.path = filepath.Join(dir, strconv.Itoa(os.Getpid())+".json")
+	return reg, reg.save()
+}
+
+func (r *registry) set(job *watchJob, state, detail string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	s := jobStatus{Name: job.Name, Watch: job.describe(), State: state, Detail: detail, Updated: now}
+	found := false
+	for i := range r.state.Jobs {
+		if r.state.Jobs[i].Name == job.Name {
+			s.Deadline = r.state.Jobs[i].Deadline
+			r.state.Jobs[i], found = s, true
+		}
+	}
+	if !found {
+		s.Deadline = now.Add(job.Walltime)
+		r.state.Jobs = append(r.state.Jobs, s)
+	}
+	if err := r.save(); err != nil {
+		logrus.Debugf("BARKER: saving the status: %s", err)
+	}
+}
+
+// save replaces the state file at once so barker status never reads half of it.
+func (r *registry) save() error {
+	if r.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(r.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+func (r *registry) close() {
+	if r.path != "" {
+		os.Remove(r.path)
+	}
+}
+
+// readStates returns the state of every barker still running, files left by dead ones are removed.
+func readStates(dir string) ([]processState, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var states []processState
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		var s processState
+		if err := json.Unmarshal(data, &s); err != nil {
+			continue
+		}
+		if !running(s.Pid) {
+			os.Remove(f)
+			continue
+		}
+		states = append(states, s)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Started.Before(states[j].Started) })
+	return states, nil
+}
+
+func status(ctx context.Context, cmd *cli.Command) error {
+	states, err := readStates(statusDir())
+	if err != nil {
+		return err
+	}
+	if len(states) == 0 {
+		fmt.Println("no barker is running")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PID\tJOB\tSTATE\tWAITING\tLEFT\tWATCH\tDETAIL")
+	now := time.Now()
+	for _, s := range states {
+		for _, j := range s.Jobs {
+			left := "-"
+			if j.State == "waiting" {
+				left = j.Deadline.Sub(now).Round(time.Second).String()
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", s.Pid, j.Name, j.State, now.Sub(s.Started).Round(time.Second), left, j.Watch, j.Detail)
+		}
+	}
+	return w.Flush()
+}
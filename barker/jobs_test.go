@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+func TestLoadJobs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.yaml")
+	os.WriteFile(path, []byte(`jobs:
+  - name: alignment
+    path: /scratch/sample.bam
+    stable: 5m
+    recipients: [me@example.org]
+    walltime: 48h
+  - pid: 4242
+    subject: pipeline is over
+`), 0o600)
+	jobs, err := loadJobs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("got %d jobs", len(jobs))
+	}
+	a, b := jobs[0], jobs[1]
+	if a.Path != "/scratch/sample.bam" || a.Stable != 5*time.Minute || a.Walltime != 48*time.Hour || a.Recipients[0] != "me@example.org" {
+		t.Fatalf("first job %+v", a)
+	}
+	if b.Name != "job2" || b.Pid != 4242 || b.Walltime != 24*time.Hour {
+		t.Fatalf("second job %+v", b)
+	}
+	if got := a.describe(); got != "/scratch/sample.bam, stable 5m0s" {
+		t.Fatalf("describe() = %q", got)
+	}
+
+	for _, bad := range []string{
+		"jobs: []\n",
+		"jobs:\n  - path: a\n    stabel: 1m\n",
+		"jobs:\n  - name: x\n    path: a\n  - name: x\n    path: b\n",
+		"jobs:\n  - path: a\n    walltime: 400h\n",
+	} {
+		os.WriteFile(path, []byte(bad), 0o600)
+		if _, err := loadJobs(path); err == nil {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+}
+
+func TestJobsRunConcurrently(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", filepath.Join(dir, "run"))
+	t.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	reg, err := newRegistry("jobs.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg.close()
+
+	jobs := []watchJob{
+		{Name: "first", conditionConfig: conditionConfig{Path: filepath.Join(dir, "a")}, Walltime: 5 * time.Second, templateConfig: templateConfig{Subject: "{{.Job}} is there"}},
+		{Name: "second", conditionConfig: conditionConfig{Path: filepath.Join(dir, "b")}, Walltime: 5 * time.Second},
+		{Name: "late", conditionConfig: conditionConfig{Path: filepath.Join(dir, "never")}, Walltime: 300 * time.Millisecond},
+	}
+	errs := make([]error, len(jobs))
+	var wg sync.WaitGroup
+	for i := range jobs {
+		if err := jobs[i].prepare(); err != nil {
+			t.Fatal(err)
+		}
+		notify := []notifier{&commandNotifier{Command: `echo "$BARKER_SUBJECT" > ` + filepath.Join(dir, jobs[i].Name+".sent")}}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = jobs[i].run(context.Background(), notify, watchOptions{Poll: 20 * time.Millisecond}, retryPolicy{Attempts: 1}, reg)
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	states, err := readStates(statusDir())
+	if err != nil || len(states) != 1 || len(states[0].Jobs) != 3 {
+		t.Fatalf("status while waiting: %+v, %v", states, err)
+	}
+	os.WriteFile(filepath.Join(dir, "a"), nil, 0o644)
+	os.WriteFile(filepath.Join(dir, "b"), nil, 0o644)
+	wg.Wait()
+
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatal(errs[0], errs[1])
+	}
+	if !errors.Is(errs[2], errWalltime) {
+		t.Fatalf("late job: %v", errs[2])
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "first.sent")); string(data) != "first is there\n" {
+		t.Fatalf("first job sent %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "second.sent")); err != nil {
+		t.Fatal("second job not notified")
+	}
+	states, _ = readStates(statusDir())
+	got := map[string]string{}
+	for _, j := range states[0].Jobs {
+		got[j.Name] = j.State
+	}
+	if got["first"] != "notified" || got["second"] != "notified" || got["late"] != "timed out" {
+		t.Fatalf("final states %v", got)
+	}
+}
+
+func TestReadStatesDropsDeadProcesses(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "999999999.json")
+	os.WriteFile(stale, []byte(`{"pid": 999999999, "jobs": []}`), 0o600)
+	states, err := readStates(dir)
+	if err != nil || len(states) != 0 {
+		t.Fatalf("got %+v, %v", states, err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatal("stale state file kept")
+	}
+}
+
+func TestJobsOutcome(t *testing.T) {
+	for _, tc := range []struct {
+		failed, timedOut int
+		msg              string
+		code             int
+	}{
+		{0, 0, "", 0},
+		{2, 0, "BARKER: 2 of 5 jobs failed", 3},
+		{0, 1, "BARKER: 1 of 5 jobs ran out of walltime", 4},
+		{1, 2, "BARKER: 1 failed, 2 ran out of walltime of 5 jobs", 3},
+	} {
+		err := jobsOutcome(tc.failed, tc.timedOut, 5)
+		if tc.code == 0 {
+			if err != nil {
+				t.Errorf("%d failed, %d timed out: %v", tc.failed, tc.timedOut, err)
+			}
+			continue
+		}
+		var exit cli.ExitCoder
+		if !errors.As(err, &exit) || exit.ExitCode() != tc.code || !strings.Contains(err.Error(), tc.msg) {
+			t.Errorf("%d failed, %d timed out: %v", tc.failed, tc.timedOut, err)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/go-mail/mail"
+	"github.com/godbus/dbus/v5"
+)
+
+// message is what every backend delivers. Backends without attachments only send the subject and body, HTML only goes by email.
+type message struct {
+	Subject     string
+	Body        string
+	HTML        string
+	Path        string
+	Time        time.Time
+	Attachments []attachment
+}
+
+type attachment struct {
+	Name string
+	Data []byte
+}
+
+// notifier is a way of telling the user the watch is over.
+type notifier interface {
+	Notify(ctx context.Context, msg message) error
+	String() string
+	// target is the config building the notifier again, without the password, for messages left in the outbox.
+	target() config
+}
+
+type smtpNotifier struct {
+	Host     string
+	Port     int
+	TLS      string
+	Username string
+	Password string
+	From     string
+	To       []string
+
+	source smtpConfig // where the password comes from, for target
+}
+
+func newSMTPNotifier(cfg smtpConfig, password string) (*smtpNotifier, error) {
+	n := &smtpNotifier{Host: cfg.Host, Port: cfg.Port, TLS: cfg.TLS, Username: cfg.Username, Password: password, From: cfg.From, To: cfg.To}
+	n.source = cfg
+	n.source.Password = ""
+	if n.Host == "" {
+		return nil, errors.New("smtp: no host")
+	}
+	if n.Port == 0 {
+		n.Port = 587
+		if n.TLS == "tls" {
+			n.Port = 465
+		}
+	}
+	if n.TLS == "" {
+		n.TLS = "starttls"
+	}
+	switch n.TLS {
+	case "starttls", "tls", "none":
+	default:
+		return nil, fmt.Errorf("smtp: no such tls mode %q, possible values: starttls, tls, none", n.TLS)
+	}
+	if n.From == "" {
+		n.From = n.Username
+	}
+	if n.From == "" {
+		return nil, errors.New("smtp: no sender, set a username or from address")
+	}
+	if len(n.To) == 0 {
+		return nil, errors.New("smtp: no recipient")
+	}
+	return n, nil
+}
+
+func (n *smtpNotifier) String() string {
+	return fmt.Sprintf("smtp %s:%d", n.Host, n.Port)
+}
+
+func (n *smtpNotifier) target() config {
+	source := n.source
+	return config{SMTP: &source}
+}
+
+func (n *smtpNotifier) Notify(ctx context.Context, msg message) error {
+	m := mail.NewMessage()
+	m.SetHeader("From", n.From)
+	m.SetHeader("To", n.To...)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.Body)
+	if msg.HTML != "" {
+		m.AddAlternative("text/html", msg.HTML)
+	}
+	for _, a := range msg.Attachments {
+		m.AttachReader(a.Name, bytes.NewReader(a.Data))
+	}
+	d := mail.NewDialer(n.Host, n.Port, n.Username, n.Password)
+	switch n.TLS {
+	case "tls":
+		d.SSL = true
+	case "starttls":
+		d.SSL = false
+		d.StartTLSPolicy = mail.MandatoryStartTLS
+	case "none":
+		d.SSL = false
+		d.StartTLSPolicy = mail.NoStartTLS
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		d.Timeout = time.Until(deadline)
+	}
+	return d.DialAndSend(m)
+}
+
+// webhookNotifier POSTs the message as JSON.
+type webhookNotifier struct {
+	URL     string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+type webhookPayload struct {
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	Path    string    `json:"path"`
+	Time    time.Time `json:"time"`
+	Host    string    `json:"host"`
+}
+
+func (n *webhookNotifier) String() string {
+	return "webhook " + n.URL
+}
+
+func (n *webhookNotifier) target() config {
+	return config{Webhook: &webhookConfig{URL: n.URL, Headers: n.Headers}}
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, msg message) error {
+	host, _ := os.Hostname()
+	payload, err := json.Marshal(webhookPayload{Subject: msg.Subject, Body: msg.Body, Path: msg.Path, Time: msg.Time, Host: host})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range n.Headers {
+		req.Header.Set(k, v)
+	}
+	client := n.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		reply, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(reply)))
+	}
+	return nil
+}
+
+// desktopNotifier makes the org.freedesktop.Notifications call notify-send does on the session bus.
+type desktopNotifier struct{}
+
+func (desktopNotifier) String() string {
+	return "desktop"
+}
+
+func (desktopNotifier) target() config {
+	return config{Desktop: true}
+}
+
+func (desktopNotifier) Notify(ctx context.Context, msg message) error {
+	conn, err := dbus.ConnectSessionBus(dbus.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	obj := conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
+	call := obj.CallWithContext(ctx, "org.freedesktop.Notifications.Notify", 0,
+		"barker", uint32(0), "", msg.Subject, msg.Body, []string{}, map[string]dbus.Variant{}, int32(-1))
+	return call.Err
+}
+
+// commandNotifier runs a shell command with the message in its environment and the body on stdin.
+type commandNotifier struct {
+	Command string
+}
+
+func (n *commandNotifier) String() string {
+	return "command " + n.Command
+}
+
+func (n *commandNotifier) target() config {
+	return config{Command: n.Command}
+}
+
+func (n *commandNotifier) Notify(ctx context.Context, msg message) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", n.Command)
+	cmd.Env = append(os.Environ(),
+		"BARKER_SUBJECT="+msg.Subject,
+		"BARKER_BODY="+msg.Body,
+		"BARKER_PATH="+msg.Path,
+		"BARKER_TIME="+msg.Time.Format(time.RFC3339),
+	)
+	cmd.Stdin = strings.NewReader(msg.Body)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if len(out) > 0 {
+			return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP accepts one plain text session and hands back the envelope and data.
+type fakeSMTP struct {
+	listener net.Listener
+	mails    chan fakeMail
+}
+
+type fakeMail struct {
+	From string
+	To   []string
+	Data string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{listener: listener, mails: make(chan fakeMail, 8)}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.session(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTP) session(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprint(conn, line+"\r\n") }
+	reply("220 fake ESMTP")
+	var mail fakeMail
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250 fake")
+		case "MAIL":
+			mail.From = strings.Trim(strings.TrimPrefix(line[len("MAIL FROM:"):], " "), "<>")
+			reply("250 ok")
+		case "RCPT":
+			mail.To = append(mail.To, strings.Trim(strings.TrimPrefix(line[len("RCPT TO:"):], " "), "<>"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			mail.Data = data.String()
+			s.mails <- mail
+			mail = fakeMail{}
+			reply("250 queued")
+		case "RSET", "NOOP":
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func testMessage() message {
+	return message{
+		Subject:     "Barker: done",
+		Body:        "your file is there",
+		Path:        "/tmp/out.txt",
+		Time:        time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+		Attachments: []attachment{{Name: "info.txt", Data: []byte("Size: 3\n")}},
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	server := newFakeSMTP(t)
+	n, err := newSMTPNotifier(smtpConfig{Host: "127.0.0.1", Port: server.port(), TLS: "none", From: "barker@example.org", To: []string{"me@example.org", "you@example.org"}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := n.Notify(ctx, testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case mail := <-server.mails:
+		if mail.From != "barker@example.org" || strings.Join(mail.To, ",") != "me@example.org,you@example.org" {
+			t.Fatalf("envelope %s -> %v", mail.From, mail.To)
+		}
+		for _, want := range []string{"Subject: Barker: done", "your file is there", `filename="info.txt"`} {
+			if !strings.Contains(mail.Data, want) {
+				t.Errorf("mail has no %q:\n%s", want, mail.Data)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail received")
+	}
+}
+
+func TestSMTPNotifierRequiresStartTLS(t *testing.T) {
+	server := newFakeSMTP(t)
+	n, err := newSMTPNotifier(smtpConfig{Host: "127.0.0.1", Port: server.port(), From: "barker@example.org", To: []string{"me@example.org"}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), testMessage()); err == nil {
+		t.Fatal("sent in the clear to a server without STARTTLS")
+	}
+}
+
+func TestSMTPNotifierConfig(t *testing.T) {
+	for _, cfg := range []smtpConfig{
+		{To: []string{"me@example.org"}, From: "a@example.org"},
+		{Host: "mail", From: "a@example.org"},
+		{Host: "mail", To: []string{"me@example.org"}},
+		{Host: "mail", To: []string{"me@example.org"}, From: "a@example.org", TLS: "ssl3"},
+	} {
+		if _, err := newSMTPNotifier(cfg, ""); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+	n, err := newSMTPNotifier(smtpConfig{Host: "mail", TLS: "tls", Username: "a@example.org", To: []string{"me@example.org"}}, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Port != 465 || n.From != "a@example.org" {
+		t.Fatalf("defaults not applied: %+v", n)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	got := make(chan webhookPayload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Token") != "secret" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var p webhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		got <- p
+	}))
+	defer server.Close()
+
+	n := &webhookNotifier{URL: server.URL, Headers: map[string]string{"X-Token": "secret"}}
+	if err := n.Notify(context.Background(), testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	p := <-got
+	if p.Subject != "Barker: done" || p.Path != "/tmp/out.txt" || !p.Time.Equal(testMessage().Time) || p.Host == "" {
+		t.Fatalf("payload %+v", p)
+	}
+
+	n.Headers = nil
+	if err := n.Notify(context.Background(), testMessage()); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Fatalf("got %v, want the 400 reply", err)
+	}
+}
+
+func TestCommandNotifier(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "hook")
+	n := &commandNotifier{Command: `{ echo "$BARKER_SUBJECT|$BARKER_PATH"; cat; } > ` + out}
+	if err := n.Notify(context.Background(), testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "Barker: done|/tmp/out.txt\nyour file is there" {
+		t.Fatalf("got %q", data)
+	}
+	if err := (&commandNotifier{Command: "echo broken >&2; exit 3"}).Notify(context.Background(), testMessage()); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("got %v, want the command's output", err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("smtp:\n  host: mail.example.org\n  port: 2525\n  to: [me@example.org]\nwebhook:\n  url: http://localhost/x\ndesktop: true\n"), 0o600)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.SMTP == nil || cfg.SMTP.Port != 2525 || cfg.Webhook == nil || !cfg.Desktop {
+		t.Fatalf("got %+v", cfg)
+	}
+	os.WriteFile(path, []byte("smtp:\n  hots: typo\n"), 0o600)
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("unknown key accepted")
+	}
+	if _, err := loadConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatal("missing explicit config accepted")
+	}
+}
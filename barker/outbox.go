@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pydpll/errorutils"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v3"
+)
+
+// attemptTimeout bounds a single delivery, a server that hangs is retried like one that refuses.
+const attemptTimeout = 2 * time.Minute
+
+var flushCmd = &cli.Command{
+	Name:  "flush",
+	Usage: "deliver again the messages left in the outbox",
+	Description: "a message is left in the outbox when a notifier still failed after its retries.\n" +
+		"flush tries every one of them once, delivered ones leave the outbox, the others stay for the next flush.\n" +
+		"smtp passwords are looked up again, from the sources saved with the message or the smtp section of --config.",
+	Action: flush,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "list",
+			Usage: "only list the messages in the outbox",
+		},
+	},
+}
+
+// retryPolicy is how hard a notifier is tried before its message goes to the outbox.
+type retryPolicy struct {
+	Attempts int           // tries in total, at least one
+	Wait     time.Duration // before the second try, doubled after every failure
+	MaxWait  time.Duration
+}
+
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	wait := p.Wait
+	for range attempt - 1 {
+		wait *= 2
+		if p.MaxWait > 0 && wait >= p.MaxWait {
+			return p.MaxWait
+		}
+	}
+	return wait
+}
+
+// stateDir keeps what outlives a barker: the outbox and the log of sent notifications.
+func stateDir() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return filepath.Join(os.TempDir(), "barker-state-"+strconv.Itoa(os.Getuid()))
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "barker")
+}
+
+func outboxDir() string {
+	return filepath.Join(stateDir(), "outbox")
+}
+
+// deliver tells every notifier at once, retrying each with the policy so one that is down never holds the others back.
+// A notifier still failing leaves the message in the outbox. Waiting between tries stops when ctx is done, the message goes to the outbox right away.
+func deliver(ctx context.Context, job string, notifiers []notifier, msg message, policy retryPolicy) error {
+	errs := make([]error, len(notifiers))
+	var wg sync.WaitGroup
+	for i, n := range notifiers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = deliverOne(ctx, job, n, msg, policy)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func deliverOne(ctx context.Context, job string, n notifier, msg message, policy retryPolicy) error {
+	attempts, err := notifyWithRetry(ctx, n, msg, policy)
+	if err == nil {
+		logSent(job, n, msg, "sent", attempts, nil)
+		return nil
+	}
+	path, saveErr := saveOutbox(outboxEntry{Job: job, Notifier: n.String(), Target: n.target(), Message: msg, Attempts: attempts, Failed: time.Now(), Error: err.Error()})
+	if saveErr != nil {
+		logSent(job, n, msg, "lost", attempts, err)
+		return fmt.Errorf("%s: %w, and the message could not be kept: %v", n, err, saveErr)
+	}
+	logSent(job, n, msg, "outbox", attempts, err)
+	return fmt.Errorf("%s: %w, kept in %s for barker flush", n, err, path)
+}
+
+func notifyWithRetry(ctx context.Context, n notifier, msg message, policy retryPolicy) (int, error) {
+	var err error
+	attempt := 0
+	for {
+		attempt++
+		actx, cancel := context.WithTimeout(context.WithoutCancel(ctx), attemptTimeout)
+		err = n.Notify(actx, msg)
+		cancel()
+		if err == nil || attempt >= policy.Attempts {
+			return attempt, err
+		}
+		wait := policy.backoff(attempt)
+		logrus.Warnf("BARKER: %s: %s, try %d of %d in %s", n, err, attempt+1, policy.Attempts, wait)
+		select {
+		case <-ctx.Done():
+			return attempt, err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// outboxEntry is a message one notifier could not deliver.
+type outboxEntry struct {
+	Job      string    `json:"job"`
+	Notifier string    `json:"notifier"`
+	Target   config    `json:"target"`
+	Message  message   `json:"message"`
+	Attempts int       `json:"attempts"`
+	Failed   time.Time `json:"failed"`
+	Error    string    `json:"error"`
+
+	path string
+}
+
+// saveOutbox writes the entry under a new name, messages and headers stay private to the user.
+func saveOutbox(e outboxEntry) (string, error) {
+	dir := outboxDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(dir, e.Failed.Format("20060102T150405")+"-*.json")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// readOutbox returns the entries oldest first.
+func readOutbox(dir string) ([]outboxEntry, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var entries []outboxEntry
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var e outboxEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, fmt.Errorf("outbox %s: %w", f, err)
+		}
+		e.path = f
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Failed.Before(entries[j].Failed) })
+	return entries, nil
+}
+
+// sentRecord is a line of the sent log.
+type sentRecord struct {
+	Time     time.Time `json:"time"`
+	Job      string    `json:"job"`
+	Notifier string    `json:"notifier"`
+	Subject  string    `json:"subject"`
+	Path     string    `json:"path,omitempty"`
+	Status   string    `json:"status"` // sent, outbox or lost
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// logSent appends to stateDir()/sent.log, a notification is never kept from going out by the log.
+func logSent(job string, n notifier, msg message, status string, attempts int, err error) {
+	rec := sentRecord{Time: time.Now(), Job: job, Notifier: n.String(), Subject: msg.Subject, Path: msg.Path, Status: status, Attempts: attempts}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if werr := appendSentLog(rec); werr != nil {
+		logrus.Debugf("BARKER: writing the sent log: %s", werr)
+	}
+}
+
+func appendSentLog(rec sentRecord) error {
+	if err := os.MkdirAll(stateDir(), 0o700); err != nil {
+		return err
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(stateDir(), "sent.log"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// withPassword gives a saved smtp target the password sources of cfg when it has none of its own, the password itself is never saved.
+func (e *outboxEntry) withPassword(cfg *config) config {
+	target := e.Target
+	if target.SMTP == nil || target.SMTP.PasswordCmd != "" || len(target.SMTP.Secret) > 0 || cfg.SMTP == nil {
+		return target
+	}
+	if cfg.SMTP.Host == target.SMTP.Host && (cfg.SMTP.Username == "" || cfg.SMTP.Username == target.SMTP.Username) {
+		smtp := *target.SMTP
+		smtp.PasswordCmd, smtp.Secret, smtp.Password = cfg.SMTP.PasswordCmd, cfg.SMTP.Secret, cfg.SMTP.Password
+		target.SMTP = &smtp
+	}
+	return target
+}
+
+func flush(ctx context.Context, cmd *cli.Command) error {
+	entries, err := readOutbox(outboxDir())
+	if err != nil {
+		return errorutils.NewReport("BARKER: "+err.Error(), "", errorutils.WithExitCode(1))
+	}
+	if len(entries) == 0 {
+		fmt.Println("the outbox is empty")
+		return nil
+	}
+	if cmd.Bool("list") {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "FAILED\tJOB\tNOTIFIER\tTRIES\tSUBJECT\tERROR")
+		for _, e := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", e.Failed.Format("2006-01-02 15:04:05"), e.Job, e.Notifier, e.Attempts, e.Message.Subject, e.Error)
+		}
+		return w.Flush()
+	}
+	cfg, err := loadConfig(cmd.String("config"))
+	if err != nil {
+		return errorutils.NewReport("BARKER: "+err.Error(), "", errorutils.WithExitCode(1))
+	}
+	left := 0
+	for _, e := range entries {
+		if err := flushEntry(ctx, e, cfg); err != nil {
+			errorutils.WarnOnFail(fmt.Errorf("BARKER: %s: %s: %w", e.Job, e.Notifier, err))
+			left++
+			continue
+		}
+		logrus.Info("BARKER: " + e.Job + ": delivered by " + e.Notifier)
+	}
+	if left > 0 {
+		return errorutils.NewReport(fmt.Sprintf("BARKER: %d of %d messages are still in the outbox", left, len(entries)), "", errorutils.WithExitCode(3))
+	}
+	return nil
+}
+
+// flushEntry tries the entry once, it leaves the outbox when delivered and keeps the last error otherwise.
+func flushEntry(ctx context.Context, e outboxEntry, cfg *config) error {
+	target := e.withPassword(cfg)
+	notifiers, err := target.notifiers(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if len(notifiers) != 1 {
+		return fmt.Errorf("outbox %s names %d notifiers", e.path, len(notifiers))
+	}
+	n := notifiers[0]
+	attempts, err := notifyWithRetry(ctx, n, e.Message, retryPolicy{Attempts: 1})
+	e.Attempts += attempts
+	if err != nil {
+		logSent(e.Job, n, e.Message, "outbox", e.Attempts, err)
+		e.Error = err.Error()
+		if data, merr := json.MarshalIndent(e, "", "  "); merr == nil {
+			os.WriteFile(e.path, data, 0o600)
+		}
+		return err
+	}
+	logSent(e.Job, n, e.Message, "sent", e.Attempts, nil)
+	return os.Remove(e.path)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readSentLog(t *testing.T) []sentRecord {
+	t.Helper()
+	f, err := os.Open(filepath.Join(stateDir(), "sent.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []sentRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec sentRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestDeliverRetriesAndKeepsGoing(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	tries := filepath.Join(dir, "tries")
+	out := filepath.Join(dir, "hook")
+	notifiers := []notifier{
+		&commandNotifier{Command: "exit 1"},
+		// fails twice, then works
+		&commandNotifier{Command: "echo x >> " + tries + "; [ $(wc -l < " + tries + ") -ge 3 ] && touch " + out},
+	}
+	err := deliver(context.Background(), "align", notifiers, testMessage(), retryPolicy{Attempts: 3, Wait: time.Millisecond})
+	if err == nil || !strings.Contains(err.Error(), "barker flush") {
+		t.Fatalf("got %v, want the failure and the outbox", err)
+	}
+	if _, err := os.Stat(out); err != nil {
+		t.Fatal("second notifier not retried until it worked")
+	}
+
+	entries, err := readOutbox(outboxDir())
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("outbox %+v, %v", entries, err)
+	}
+	e := entries[0]
+	if e.Job != "align" || e.Attempts != 3 || e.Target.Command != "exit 1" || e.Message.Subject != testMessage().Subject {
+		t.Fatalf("outbox entry %+v", e)
+	}
+	if info, _ := os.Stat(e.path); info.Mode().Perm() != 0o600 {
+		t.Fatalf("outbox entry mode %v", info.Mode().Perm())
+	}
+
+	records := readSentLog(t)
+	statuses := map[string]sentRecord{}
+	for _, rec := range records {
+		statuses[rec.Status] = rec
+	}
+	if len(records) != 2 || statuses["outbox"].Attempts != 3 || statuses["sent"].Attempts != 3 {
+		t.Fatalf("sent log %+v", records)
+	}
+}
+
+func TestDeliverDoesNotWaitForAFailingNotifier(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	out := filepath.Join(dir, "hook")
+	notifiers := []notifier{&commandNotifier{Command: "exit 1"}, &commandNotifier{Command: "touch " + out}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- deliver(ctx, "align", notifiers, testMessage(), retryPolicy{Attempts: 5, Wait: time.Hour})
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(out); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("second notifier waited for the backoff of the first")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err == nil || !strings.Contains(err.Error(), "barker flush") {
+		t.Fatalf("got %v, want the failure in the outbox", err)
+	}
+}
+
+func TestDeliverStopsRetryingWhenCanceled(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	err := deliver(ctx, "align", []notifier{&commandNotifier{Command: "exit 1"}}, testMessage(), retryPolicy{Attempts: 5, Wait: time.Hour})
+	if err == nil || time.Since(start) > 10*time.Second {
+		t.Fatalf("got %v after %s", err, time.Since(start))
+	}
+	if entries, _ := readOutbox(outboxDir()); len(entries) != 1 || entries[0].Attempts != 1 {
+		t.Fatalf("outbox %+v", entries)
+	}
+}
+
+func TestFlushEntry(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	out := filepath.Join(dir, "flushed")
+	for _, command := range []string{"cat > " + out, "exit 2"} {
+		_, err := saveOutbox(outboxEntry{Job: "align", Notifier: "command " + command, Target: config{Command: command}, Message: testMessage(), Attempts: 5, Failed: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, _ := readOutbox(outboxDir())
+	for _, e := range entries {
+		flushEntry(context.Background(), e, &config{})
+	}
+	if data, _ := os.ReadFile(out); string(data) != testMessage().Body {
+		t.Fatalf("flushed %q", data)
+	}
+	entries, _ = readOutbox(outboxDir())
+	if len(entries) != 1 || entries[0].Target.Command != "exit 2" || entries[0].Attempts != 6 || entries[0].Error == "" {
+		t.Fatalf("left in the outbox %+v", entries)
+	}
+}
+
+func TestOutboxPassword(t *testing.T) {
+	e := outboxEntry{Target: config{SMTP: &smtpConfig{Host: "mail", Username: "me"}}}
+	got := e.withPassword(&config{SMTP: &smtpConfig{Host: "mail", PasswordCmd: "pass show smtp"}})
+	if got.SMTP.PasswordCmd != "pass show smtp" {
+		t.Fatalf("password source of the config not used: %+v", got.SMTP)
+	}
+	if got := e.withPassword(&config{SMTP: &smtpConfig{Host: "other", PasswordCmd: "pass show other"}}); got.SMTP.PasswordCmd != "" {
+		t.Fatal("password of another server used")
+	}
+	n, _ := newSMTPNotifier(smtpConfig{Host: "mail", Username: "me", To: []string{"me"}, Password: "hunter2"}, "hunter2")
+	data, _ := json.Marshal(outboxEntry{Target: n.target()})
+	if strings.Contains(string(data), "hunter2") {
+		t.Fatal("password saved in the outbox")
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := retryPolicy{Attempts: 6, Wait: 30 * time.Second, MaxWait: 100 * time.Second}
+	var got []time.Duration
+	for attempt := 1; attempt < p.Attempts; attempt++ {
+		got = append(got, p.backoff(attempt))
+	}
+	want := []time.Duration{30 * time.Second, time.Minute, 100 * time.Second, 100 * time.Second, 100 * time.Second}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("backoff %v, want %v", got, want)
+		}
+	}
+}
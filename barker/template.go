@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultSubject = `Barker: change at {{stamp .Time}}`
+	defaultBody    = `{{stamp .Time}}
+Barker: {{join .Met ", "}}
+{{if .Exists}}
+path:     {{.Path}}
+size:     {{bytes .Size}}
+modified: {{stamp .ModTime}}
+{{end}}host:     {{.Hostname}}
+waited:   {{.Elapsed}}
+{{if .Checksum}}sha256:   {{.Checksum}}
+{{end}}{{if .Tail}}
+last lines:
+{{.Tail}}{{end}}`
+)
+
+// templateConfig is the message part of a watch. Templates see templateData, subject and body are text/template, html is html/template.
+type templateConfig struct {
+	Subject   string `yaml:"subject"`
+	Body      string `yaml:"body"`
+	BodyFile  string `yaml:"body_file"`
+	HTMLFile  string `yaml:"html_file"`
+	Tail      int    `yaml:"tail"`       // last lines of the file in .Tail
+	Checksum  bool   `yaml:"checksum"`   // sha256 of the file in .Checksum
+	AttachMax string `yaml:"attach_max"` // attach the file itself up to this size, e.g. 2M
+}
+
+// templateData is what a message template can use.
+type templateData struct {
+	Job      string
+	Path     string
+	Exists   bool
+	IsDir    bool
+	Size     int64
+	ModTime  time.Time
+	Hostname string
+	Started  time.Time
+	Time     time.Time
+	Elapsed  time.Duration
+	Met      []string
+	Tail     string
+	Checksum string
+}
+
+var templateFuncs = template.FuncMap{
+	"stamp":  func(t time.Time) string { return t.Format("2006-01-02 15:04:05") },
+	"join":   strings.Join,
+	"bytes":  humanBytes,
+	"tail":   tailLines,
+	"sha256": fileChecksum,
+}
+
+// messageTemplates are parsed once, when the watch starts, so mistakes show up before hours of waiting.
+type messageTemplates struct {
+	cfg       templateConfig
+	subject   *template.Template
+	body      *template.Template
+	html      *htmltemplate.Template
+	attachMax int64
+}
+
+func (c templateConfig) compile() (*messageTemplates, error) {
+	t := &messageTemplates{cfg: c}
+	subject := c.Subject
+	if subject == "" {
+		subject = defaultSubject
+	}
+	var err error
+	if t.subject, err = template.New("subject").Funcs(templateFuncs).Parse(subject); err != nil {
+		return nil, err
+	}
+	body := c.Body
+	if c.BodyFile != "" {
+		if body != "" {
+			return nil, errors.New("give a body or a body file, not both")
+		}
+		data, err := os.ReadFile(c.BodyFile)
+		if err != nil {
+			return nil, err
+		}
+		body = string(data)
+	}
+	if body == "" {
+		body = defaultBody
+	}
+	if t.body, err = template.New("body").Funcs(templateFuncs).Parse(body); err != nil {
+		return nil, err
+	}
+	if c.HTMLFile != "" {
+		data, err := os.ReadFile(c.HTMLFile)
+		if err != nil {
+			return nil, err
+		}
+		if t.html, err = htmltemplate.New("html").Funcs(htmltemplate.FuncMap(templateFuncs)).Parse(string(data)); err != nil {
+			return nil, err
+		}
+	}
+	if c.AttachMax != "" {
+		if t.attachMax, err = parseSize(c.AttachMax); err != nil {
+			return nil, err
+		}
+	}
+	if c.Tail < 0 {
+		return nil, fmt.Errorf("tail %d is negative", c.Tail)
+	}
+	return t, nil
+}
+
+// render fills the message of a watch that started at started and was met at now.
+func (t *messageTemplates) render(job string, conds []condition, path string, started, now time.Time) (message, error) {
+	hostname, _ := os.Hostname()
+	data := templateData{Job: job, Path: path, Hostname: hostname, Started: started, Time: now, Elapsed: now.Sub(started).Round(time.Second)}
+	for _, c := range conds {
+		data.Met = append(data.Met, c.met())
+	}
+	var info os.FileInfo
+	if path != "" {
+		if fi, err := os.Stat(path); err == nil {
+			info = fi
+			data.Exists, data.IsDir, data.Size, data.ModTime = true, fi.IsDir(), fi.Size(), fi.ModTime()
+		}
+	}
+	if info != nil && !info.IsDir() {
+		if t.cfg.Tail > 0 {
+			data.Tail, _ = tailLines(path, t.cfg.Tail)
+		}
+		if t.cfg.Checksum {
+			data.Checksum, _ = fileChecksum(path)
+		}
+	}
+
+	msg := message{Path: path, Time: now}
+	var b strings.Builder
+	if err := t.subject.Execute(&b, data); err != nil {
+		return msg, err
+	}
+	msg.Subject = strings.TrimSpace(strings.ReplaceAll(b.String(), "\n", " "))
+	b.Reset()
+	if err := t.body.Execute(&b, data); err != nil {
+		return msg, err
+	}
+	msg.Body = b.String()
+	if t.html != nil {
+		b.Reset()
+		if err := t.html.Execute(&b, data); err != nil {
+			return msg, err
+		}
+		msg.HTML = b.String()
+	}
+	if info != nil && info.Mode().IsRegular() && t.attachMax > 0 && info.Size() <= t.attachMax {
+		content, err := os.ReadFile(path)
+		if err == nil && int64(len(content)) <= t.attachMax {
+			msg.Attachments = append(msg.Attachments, attachment{Name: filepath.Base(path), Data: content})
+		}
+	}
+	return msg, nil
+}
+
+// tailLines reads the end of the file only, outputs can be large.
+func tailLines(path string, n int) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	const chunk = 64 << 10
+	var data []byte
+	for offset := info.Size(); offset > 0 && bytes.Count(data, []byte{'\n'}) <= n; {
+		size := int64(chunk)
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+		buf := make([]byte, size)
+		if _, err := f.ReadAt(buf, offset); err != nil && err != io.EOF {
+			return "", err
+		}
+		data = append(buf, data...)
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// parseSize reads a byte count with an optional K, M or G suffix.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, errors.New("empty size")
+	}
+	mult := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("size %q is not a number with an optional K, M or G suffix", s)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderDefaultBody(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.log")
+	os.WriteFile(path, []byte("one\ntwo\nthree\nfour\n"), 0o644)
+	tmpl, err := templateConfig{Tail: 2, Checksum: true, AttachMax: "1K"}.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := time.Date(2024, 5, 6, 7, 0, 0, 0, time.Local)
+	msg, err := tmpl.render("align", []condition{&existsCond{path: path}}, path, started, started.Add(90*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "Barker: change at 2024-05-06 08:30:00" {
+		t.Errorf("subject %q", msg.Subject)
+	}
+	for _, want := range []string{
+		"Barker: your file " + path + " has been created",
+		"size:     19 B",
+		"waited:   1h30m0s",
+		"sha256:   " + mustChecksum(t, path),
+		"last lines:\nthree\nfour\n",
+	} {
+		if !strings.Contains(msg.Body, want) {
+			t.Errorf("body has no %q:\n%s", want, msg.Body)
+		}
+	}
+	if len(msg.Attachments) != 1 || msg.Attachments[0].Name != "job.log" || len(msg.Attachments[0].Data) != 19 {
+		t.Errorf("attachments %+v", msg.Attachments)
+	}
+
+	small, _ := templateConfig{AttachMax: "10"}.compile()
+	if msg, _ := small.render("align", nil, path, started, started); len(msg.Attachments) != 0 {
+		t.Error("file above attach_max attached")
+	}
+}
+
+func mustChecksum(t *testing.T, path string) string {
+	t.Helper()
+	sum, err := fileChecksum(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sum
+}
+
+func TestRenderCustomTemplates(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.txt")
+	os.WriteFile(path, []byte("a\nb\n"), 0o644)
+	htmlFile := filepath.Join(dir, "mail.html")
+	os.WriteFile(htmlFile, []byte(`<p>{{.Job}} wrote <b>{{.Path}}</b></p>`), 0o644)
+	tmpl, err := templateConfig{
+		Subject:  "{{.Job}}\non {{.Hostname}}",
+		Body:     `{{tail .Path 1}}{{bytes .Size}}`,
+		HTMLFile: htmlFile,
+	}.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := tmpl.render("<job>", nil, path, time.Now(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostname, _ := os.Hostname()
+	if msg.Subject != "<job> on "+hostname {
+		t.Errorf("subject %q", msg.Subject)
+	}
+	if msg.Body != "b\n4 B" {
+		t.Errorf("body %q", msg.Body)
+	}
+	if msg.HTML != "<p>&lt;job&gt; wrote <b>"+path+"</b></p>" {
+		t.Errorf("html %q", msg.HTML)
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	for _, cfg := range []templateConfig{
+		{Subject: "{{.Job"},
+		{Body: "{{nosuchfunc .Path}}"},
+		{Body: "x", BodyFile: "y"},
+		{HTMLFile: "/nonexistent/mail.html"},
+		{AttachMax: "2X"},
+		{Tail: -1},
+	} {
+		if _, err := cfg.compile(); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+}
+
+func TestTailLinesLargeFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "big.log")
+	f, _ := os.Create(path)
+	for i := range 50000 {
+		fmt.Fprintf(f, "line %d\n", i)
+	}
+	f.Close()
+	got, err := tailLines(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "line 49997\nline 49998\nline 49999\n" {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pydpll/errorutils"
+	"github.com/sirupsen/logrus"
+)
+
+// watchOptions chooses how the conditions are woken up. Events make barker react right away, the poll keeps going underneath them
+// for what events cannot tell (a file that stopped growing, a pid) and for filesystems where inotify stays silent.
+type watchOptions struct {
+	Poll      time.Duration // interval between checks that do not wait for events
+	PollOnly  bool          // skip inotify altogether
+	MaxErrors int           // consecutive failed checks tolerated before giving up
+}
+
+// watchedDir is implemented by conditions whose changes show up as events in a directory.
+type watchedDir interface {
+	watchDir() string
+}
+
+func (c *existsCond) watchDir() string   { return filepath.Dir(c.path) }
+func (c *stableCond) watchDir() string   { return filepath.Dir(c.path) }
+func (c *containsCond) watchDir() string { return filepath.Dir(c.path) }
+func (c *entriesCond) watchDir() string  { return c.path }
+
+// watchDir is the part of the pattern before its first wildcard, deeper matches are left to the poll.
+func (c *globCond) watchDir() string {
+	dir := c.pattern
+	for strings.ContainsAny(dir, `*?[\`) {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// waitFor returns once every condition holds, with ctx's error when it ends first. A check failing MaxErrors times in a row is returned.
+func waitFor(ctx context.Context, conds []condition, opts watchOptions) error {
+	if opts.Poll <= 0 {
+		opts.Poll = 5 * time.Second
+	}
+	var events <-chan fsnotify.Event
+	var watchErrs <-chan error
+	w := newDirWatcher(conds, opts.PollOnly)
+	if w != nil {
+		defer w.Close()
+		events, watchErrs = w.watcher.Events, w.watcher.Errors
+	}
+	tick := time.NewTicker(opts.Poll)
+	defer tick.Stop()
+
+	var errCounter int
+	check := func(now time.Time) (bool, error) {
+		done, err := checkAll(conds, now)
+		if done {
+			return true, nil
+		}
+		if err == nil {
+			errCounter = 0
+			return false, nil
+		}
+		errCounter++
+		if errCounter > opts.MaxErrors {
+			return false, err
+		}
+		errorutils.WarnOnFail(err)
+		return false, nil
+	}
+	if done, err := check(time.Now()); done || err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-tick.C:
+			if w != nil {
+				w.update() // directories that appeared since, inotify cannot see them coming
+			}
+			if done, err := check(now); done || err != nil {
+				return err
+			}
+		case event := <-events:
+			logrus.Debugf("BARKER: %s", event)
+			if event.Has(fsnotify.Create) {
+				w.update()
+			}
+			if done, err := check(time.Now()); done || err != nil {
+				return err
+			}
+		case err := <-watchErrs:
+			errorutils.WarnOnFail(err, errorutils.WithMsg("BARKER: inotify failed, relying on polling"))
+		}
+	}
+}
+
+// dirWatcher keeps an inotify watch on the closest existing ancestor of every directory the conditions care about.
+type dirWatcher struct {
+	watcher *fsnotify.Watcher
+	dirs    []string
+	watched map[string]bool
+}
+
+// newDirWatcher returns nil when there is nothing inotify could help with.
+func newDirWatcher(conds []condition, pollOnly bool) *dirWatcher {
+	if pollOnly {
+		return nil
+	}
+	var dirs []string
+	for _, c := range conds {
+		if d, ok := c.(watchedDir); ok {
+			dirs = append(dirs, d.watchDir())
+		}
+	}
+	if len(dirs) == 0 {
+		return nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		errorutils.WarnOnFail(err, errorutils.WithMsg("BARKER: no inotify, polling"))
+		return nil
+	}
+	w := &dirWatcher{watcher: watcher, dirs: dirs, watched: make(map[string]bool)}
+	w.update()
+	return w
+}
+
+func (w *dirWatcher) update() {
+	for _, dir := range w.dirs {
+		target := existingAncestor(dir)
+		if w.watched[target] {
+			continue
+		}
+		w.watched[target] = true // also when skipped, the decision holds for the whole wait
+		if fs, remote := remoteFS(target); remote {
+			logrus.Debugf("BARKER: %s is on %s, inotify does not see changes made by other hosts, polling it", target, fs)
+			continue
+		}
+		if err := w.watcher.Add(target); err != nil {
+			errorutils.WarnOnFail(err, errorutils.WithMsg("BARKER: cannot watch "+target+", polling it"))
+		}
+	}
+}
+
+func (w *dirWatcher) Close() error {
+	return w.watcher.Close()
+}
+
+// existingAncestor lets a watch wait on a path several directories away from existing.
+func existingAncestor(dir string) string {
+	for {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// remoteFilesystems are statfs magic numbers of filesystems shared between hosts.
+var remoteFilesystems = map[int64]string{
+	0x6969:     "nfs",
+	0x517b:     "smb",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x65735546: "fuse",
+	0x0bd00bd0: "lustre",
+	0x47504653: "gpfs",
+	0x5346414f: "afs",
+	0x00c36400: "ceph",
+	0x19830326: "beegfs",
+}
+
+func remoteFS(path string) (string, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return "", false
+	}
+	name, ok := remoteFilesystems[int64(st.Type)]
+	return name, ok
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitAsync runs waitFor with a poll too slow to matter, only events can end it in time.
+func waitAsync(t *testing.T, cfg conditionConfig, opts watchOptions) <-chan error {
+	t.Helper()
+	conds := mustBuild(t, cfg)
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- waitFor(ctx, conds, opts)
+	}()
+	time.Sleep(50 * time.Millisecond) // let the watches settle
+	return done
+}
+
+func TestWaitForEventInMissingDirectory(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "a", "b", "done.txt")
+	done := waitAsync(t, conditionConfig{Path: path}, watchOptions{Poll: time.Hour})
+	os.MkdirAll(filepath.Dir(path), 0o755)
+	time.Sleep(50 * time.Millisecond)
+	os.WriteFile(path, nil, 0o644)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("creation not noticed without polling")
+	}
+}
+
+func TestWaitForContentEvent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.log")
+	os.WriteFile(path, []byte("running\n"), 0o644)
+	done := waitAsync(t, conditionConfig{Path: path, Contains: "Finished"}, watchOptions{Poll: time.Hour})
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	defer f.Close()
+	f.WriteString("Finished\n")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("write not noticed without polling")
+	}
+}
+
+func TestWaitForPollOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "done.txt")
+	done := waitAsync(t, conditionConfig{Path: path}, watchOptions{Poll: 20 * time.Millisecond, PollOnly: true})
+	os.WriteFile(path, nil, 0o644)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("poll did not notice the file")
+	}
+}
+
+func TestWaitForDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := waitFor(ctx, mustBuild(t, conditionConfig{Path: filepath.Join(t.TempDir(), "never")}), watchOptions{Poll: 10 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestGlobWatchDir(t *testing.T) {
+	for pattern, want := range map[string]string{
+		"/data/out/*.bam":     "/data/out",
+		"/data/run*/x.txt":    "/data",
+		"relative/[ab]/c.txt": "relative",
+	} {
+		if got := (&globCond{pattern: pattern}).watchDir(); got != want {
+			t.Errorf("%s: got %s, want %s", pattern, got, want)
+		}
+	}
+}
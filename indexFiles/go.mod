@@ -1,13 +1,26 @@
 module github.com/jmonroynieto/cliWorkflow_tk/indexFiles
 
-go 1.24
-
-toolchain go1.24.0
+go 1.24.1
 
 require (
+	github.com/jmonroynieto/cliWorkflow_tk/kwiqExt v0.0.0-00010101000000-000000000000
 	github.com/pydpll/errorutils v0.2.1-0.20250330233827-f8d5de79edae
 	github.com/sirupsen/logrus v1.9.3
-	github.com/urfave/cli/v3 v3.0.0-beta1
+	github.com/urfave/cli/v3 v3.3.8
+	modernc.org/sqlite v1.34.5
+)
+
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/h2non/filetype v1.1.3 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	modernc.org/libc v1.55.3 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.8.0 // indirect
 )
 
-require golang.org/x/sys v0.30.0 // indirect
+replace github.com/jmonroynieto/cliWorkflow_tk/kwiqExt => ../kwiqExt
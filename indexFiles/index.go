@@ -10,23 +10,78 @@ import (
 	"sync"
 
 	"github.com/pydpll/errorutils"
+	"github.com/sirupsen/logrus"
 )
 
 type FileInfo struct {
 	Path     string
 	Identity string
 	IsSym    bool
+	Size     int64
+	Category string // only filled with --types
+	Mode     os.FileMode
+	ID       fileID
+	Nlink    uint64
+	LinkOf   string // first path of the hardlink group, empty for the first one itself
 }
 
-func (f FileInfo) String() string {
-	var t string = "file"
-	if f.IsSym {
-		t = "symlink"
+// indexSink receives every processed file, Close is called once the walk is finished.
+type indexSink interface {
+	Write(FileInfo) error
+	Close() error
+}
+
+type tsvSink struct {
+	f      *os.File
+	writer *bufio.Writer
+}
+
+func newTSVSink(outputPath string) (*tsvSink, error) {
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	writer := bufio.NewWriter(f)
+	// Write header
+	header := "Path\tidentity\tSymlink\n"
+	if typesEnabled {
+		header = "Path\tidentity\tSymlink\tCategory\n"
+	}
+	if _, err = writer.WriteString(header); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error writing header: %w", err)
 	}
-	return fmt.Sprintf("%s: %s", t, f.Path)
+	return &tsvSink{f: f, writer: writer}, nil
+}
+
+func (s *tsvSink) Write(info FileInfo) error {
+	if typesEnabled {
+		_, err := fmt.Fprintf(s.writer, "%s\t%s\t%t\t%s\n", info.Path, info.Identity, info.IsSym, info.Category)
+		return err
+	}
+	_, err := fmt.Fprintf(s.writer, "%s\t%s\t%t\n", info.Path, info.Identity, info.IsSym)
+	return err
+}
+
+func (s *tsvSink) Close() error {
+	if err := s.writer.Flush(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+func (f FileInfo) String() string {
+	return fmt.Sprintf("%s: %s", fileKind(f.Mode), f.Path)
 }
 
-func run(dirPath, outputPath string) {
+const (
+	// maxHashSize is the largest file that gets read, bigger ones are recorded as skipped
+	maxHashSize     = 90 << 20
+	skippedIdentity = "SKIPPED...too big"
+)
+
+func run(dirPath string, sinks []indexSink, stats *scanStats, errs *errorLog) {
 	fileInfoChan := make(chan FileInfo, 4094)
 	fileRequestChan := make(chan string, 4094)
 	var wg sync.WaitGroup
@@ -34,63 +89,41 @@ func run(dirPath, outputPath string) {
 	wgWrite.Add(1)
 	go func() {
 		defer wgWrite.Done()
-		f, err := os.Create(outputPath)
-		errorutils.ExitOnFail(err, errorutils.WithMsg(fmt.Sprintf("Error opening target file %s", outputPath)))
-		defer f.Close()
-		writer := bufio.NewWriter(f)
-		defer writer.Flush()
-		// Write header
-		_, err = writer.WriteString("Path\tidentity\tSymlink\n")
-		if err != nil {
-			fmt.Println("Error writing header:", err)
-			return // writer goroutine
-		}
-		var errorCounter int
 		for info := range fileInfoChan {
-			if errorCounter > 5 {
-				fmt.Fprintf(os.Stderr, "Too many errors, terminating worker")
-				return // writer goroutine
+			for _, sink := range sinks {
+				if err := sink.Write(info); err != nil {
+					errs.add(info.Path, "write", err)
+				}
 			}
-			line := fmt.Sprintf("%s\t%s\t%t\n", info.Path, info.Identity, info.IsSym)
-			_, err := writer.WriteString(line)
-			if err != nil {
-				errorCounter++
-				errorutils.WarnOnFail(err, errorutils.WithMsg(fmt.Sprintf("error writting %s", info)))
+		}
+		for _, sink := range sinks {
+			if err := sink.Close(); err != nil {
+				errs.add(dirPath, "close", err)
 			}
 		}
 	}()
-	wg.Add(1)
-	go func(dirPath string, fileRequestChan chan string, wg *sync.WaitGroup) {
-		defer wg.Done()
-		entries, err := os.ReadDir(dirPath)
-		errorutils.WarnOnFail(err, errorutils.WithMsg("couldn't read target dir "+dirPath))
-
-		for _, entry := range entries {
-			fullPath := filepath.Join(dirPath, entry.Name())
-			if entry.IsDir() {
-				err := walkDir(fullPath, fileRequestChan, wg)
-				errorutils.WarnOnFail(err, errorutils.WithMsg("Error walking subdirectory:"))
-				continue
-			}
-			fileRequestChan <- fullPath
 
-		}
-		if err != nil {
-			fmt.Println("Error walking directory:", err)
-		}
-		close(fileRequestChan)
-	}(dirPath, fileRequestChan, &wg)
+	go walk(dirPath, fileRequestChan, stats, errs)
 
+	links := newLinkRegistry()
 	for range workersNum {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			copyBuf := make([]byte, 1024*1024*512)
 			for path := range fileRequestChan {
-				fileInfo, err := processFile(path, &copyBuf)
+				fileInfo, err := processFile(path, &copyBuf, links)
+				if err == nil && typesEnabled {
+					if typeErr := categorize(&fileInfo); typeErr != nil {
+						errs.add(path, "type", typeErr)
+					}
+				}
+				stats.done(fileInfo, err)
 				if err != nil {
-					fmt.Println("Error processing file:", path, err)
-					return // worker goroutine
+					errs.add(path, "hash", err)
+					if !fileInfo.IsSym { // symlinks are still listed with whatever could be resolved
+						continue
+					}
 				}
 				fileInfoChan <- fileInfo
 			}
@@ -101,44 +134,103 @@ func run(dirPath, outputPath string) {
 	wgWrite.Wait()
 }
 
-func walkDir(dirPath string, fileRequestChan chan string, wg *sync.WaitGroup) error {
-	entries, err := os.ReadDir(dirPath)
-	errorutils.WarnOnFail(err, errorutils.WithMsg("couldn't read target dir "+dirPath))
+// dirID identifies the directories walk enters, tests swap it to place a directory on another device.
+var dirID = statID
 
-	for _, entry := range entries {
-		fullPath := filepath.Join(dirPath, entry.Name())
-		if entry.IsDir() {
-			err := walkDir(fullPath, fileRequestChan, wg)
-			errorutils.WarnOnFail(err, errorutils.WithMsg("Error walking subdirectory:"))
+// walk traverses dirPath depth first with an explicit stack so deep trees do not grow the goroutine stack. Unreadable directories are recorded and skipped.
+//
+// Directories are remembered by device and inode, with --follow-symlinks a directory reached twice (a loop or a second link to it) is only walked the first time. With --one-file-system directories on another device than dirPath are not entered.
+func walk(dirPath string, fileRequestChan chan<- string, stats *scanStats, errs *errorLog) {
+	defer close(fileRequestChan)
+	defer stats.walkDone.Store(true)
+	rootID, err := dirID(dirPath)
+	if err != nil {
+		errs.add(dirPath, "walk", err)
+		return
+	}
+	visited := make(map[fileID]bool)
+	stack := []string{dirPath}
+	var linked []string // symlinked directories wait for the real tree so its paths are the ones recorded
+	for len(stack) > 0 || len(linked) > 0 {
+		if len(stack) == 0 {
+			stack, linked = linked, nil
+		}
+		dir := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		id, err := dirID(dir)
+		if err != nil {
+			errs.add(dir, "walk", err)
 			continue
 		}
-		fileRequestChan <- fullPath
-
+		if visited[id] {
+			stats.revisits.Add(1)
+			logrus.Debugf("%s was already walked, skipping", dir)
+			continue
+		}
+		visited[id] = true
+		if oneFileSystem && id.dev != rootID.dev {
+			stats.crossings.Add(1)
+			logrus.Debugf("%s is on another filesystem, skipping", dir)
+			continue
+		}
+		// ReadDir returns the entries read before an error, index those anyway
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			errs.add(dir, "walk", err)
+		}
+		stats.dirs.Add(1)
+		for _, entry := range entries {
+			fullPath := filepath.Join(dir, entry.Name())
+			if entry.IsDir() {
+				stack = append(stack, fullPath)
+				continue
+			}
+			if followSymlinks && entry.Type()&os.ModeSymlink != 0 {
+				if target, err := os.Stat(fullPath); err == nil && target.IsDir() {
+					linked = append(linked, fullPath)
+				}
+			}
+			stats.found(entry)
+			fileRequestChan <- fullPath
+		}
 	}
-	return nil
 }
 
-func processFile(path string, copyBuf *[]byte) (FileInfo, error) {
+func processFile(path string, copyBuf *[]byte, links *linkRegistry) (FileInfo, error) {
 	info, err := os.Lstat(path)
 	if err != nil {
 		return FileInfo{}, err
 	}
 
-	fileInfo := FileInfo{Path: path}
+	fileInfo := FileInfo{Path: path, Size: info.Size(), Mode: info.Mode()}
+	fileInfo.ID, fileInfo.Nlink, _ = idOf(info)
 	if info.Mode()&os.ModeSymlink != 0 {
 		fileInfo.IsSym = true
 		fileInfo.Identity, err = filepath.EvalSymlinks(path)
 		if err != nil && os.IsNotExist(err) {
 			target, err := os.Readlink(path)
-			errorutils.WarnOnFail(err, errorutils.WithMsg("could not grab target for "+path))
 			fileInfo.Identity = "BROKEN:" + target
+			if err != nil {
+				return fileInfo, fmt.Errorf("could not grab symlink target: %w", err)
+			}
 		} else if err != nil {
-			errorutils.WarnOnFail(err, errorutils.WithMsg(fmt.Sprintf("%s is Symlink, evaluation failed", path)))
+			return fileInfo, fmt.Errorf("symlink evaluation failed: %w", err)
 		}
 		return fileInfo, nil
 	}
-	if fileSizeMB := float64(info.Size()) / (1 << 20); fileSizeMB > 90 {
-		fileInfo.Identity = "SKIPPED...too big"
+	if !info.Mode().IsRegular() {
+		fileInfo.Identity = "SPECIAL:" + fileKind(info.Mode())
+		return fileInfo, nil
+	}
+	if fileInfo.Nlink > 1 {
+		if leader, first := links.claim(fileInfo.ID, path); !first {
+			fileInfo.LinkOf = leader
+			fileInfo.Identity = "HARDLINK:" + leader
+			return fileInfo, nil
+		}
+	}
+	if info.Size() > maxHashSize {
+		fileInfo.Identity = skippedIdentity
 		return fileInfo, nil
 	}
 	result, err := checksum(path, copyBuf)
@@ -149,12 +241,15 @@ func processFile(path string, copyBuf *[]byte) (FileInfo, error) {
 	return fileInfo, nil
 }
 
+// openFile opens the files to hash, tests swap it to make some unreadable.
+var openFile = os.Open
+
 // https://stackoverflow.com/q/60328216/4343913
 func checksum(file string, copyBuf *[]byte) (string, error) {
 	if IsIgnored(file) {
 		return "IGNORED", nil
 	}
-	f, err := os.Open(file)
+	f, err := openFile(file)
 	if err != nil {
 		return "", err
 	}
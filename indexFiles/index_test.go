@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// walked runs walk on root and returns the paths handed to the workers, relative to root.
+func walked(t *testing.T, root string) ([]string, *scanStats, *errorLog) {
+	t.Helper()
+	paths := make(chan string)
+	stats, errs := newScanStats(), &errorLog{}
+	go walk(root, paths, stats, errs)
+	var got []string
+	for path := range paths {
+		rel, _ := filepath.Rel(root, path)
+		got = append(got, rel)
+	}
+	slices.Sort(got)
+	return got, stats, errs
+}
+
+func TestWalkSymlinkLoop(t *testing.T) {
+	followSymlinks = true
+	t.Cleanup(func() { followSymlinks = false })
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "a"), 0o755)
+	os.WriteFile(filepath.Join(root, "a/f"), []byte("x"), 0o644)
+	os.Symlink("..", filepath.Join(root, "a/loop"))
+	os.Symlink("a", filepath.Join(root, "self"))
+
+	got, stats, errs := walked(t, root)
+	if !slices.Equal(got, []string{"a/f", "a/loop", "self"}) {
+		t.Fatalf("walked %v", got)
+	}
+	if n := stats.revisits.Load(); n != 2 {
+		t.Fatalf("revisits %d", n)
+	}
+	if errs.len() != 0 {
+		t.Fatalf("errors %v", errs.errs)
+	}
+}
+
+func TestWalkOneFileSystem(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "mnt/usb"), 0o755)
+	os.WriteFile(filepath.Join(root, "mnt/usb/photo"), nil, 0o644)
+	os.WriteFile(filepath.Join(root, "top"), nil, 0o644)
+	dirID = func(path string) (fileID, error) {
+		id, err := statID(path)
+		if strings.HasSuffix(path, "usb") {
+			id.dev++
+		}
+		return id, err
+	}
+	t.Cleanup(func() { dirID, oneFileSystem = statID, false })
+
+	if got, _, _ := walked(t, root); !slices.Equal(got, []string{"mnt/usb/photo", "top"}) {
+		t.Fatalf("walked %v without --one-file-system", got)
+	}
+	oneFileSystem = true
+	got, stats, _ := walked(t, root)
+	if !slices.Equal(got, []string{"top"}) {
+		t.Fatalf("walked %v", got)
+	}
+	if n := stats.crossings.Load(); n != 1 {
+		t.Fatalf("crossings %d", n)
+	}
+}
+
+func TestHardlinkLeader(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+	os.WriteFile(first, []byte("shared"), 0o644)
+	if err := os.Link(first, second); err != nil {
+		t.Skip(err)
+	}
+	buf := make([]byte, 1024)
+	hash, _ := checksum(first, &buf)
+	links, stats := newLinkRegistry(), newScanStats()
+	var infos []FileInfo
+	for _, path := range []string{first, second} {
+		info, err := processFile(path, &buf, links)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats.done(info, nil)
+		infos = append(infos, info)
+	}
+	if infos[0].Identity != hash || infos[0].LinkOf != "" {
+		t.Fatalf("leader %+v", infos[0])
+	}
+	if infos[1].Identity != "HARDLINK:"+first || infos[1].LinkOf != first {
+		t.Fatalf("second link %+v", infos[1])
+	}
+	if stats.bytesDone.Load() != int64(len("shared")) || stats.hardlinks.Load() != 1 {
+		t.Fatalf("hashed %d bytes, %d extra hardlinks", stats.bytesDone.Load(), stats.hardlinks.Load())
+	}
+}
+
+// collected keeps what run hands to its sinks, Write is only called from one goroutine.
+type collected struct {
+	paths  []string
+	closed bool
+}
+
+func (c *collected) Write(info FileInfo) error {
+	c.paths = append(c.paths, info.Path)
+	return nil
+}
+
+func (c *collected) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestRunCollectsErrors(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "sub"), 0o755)
+	for _, name := range []string{"a", "bad", "sub/b", "sub/bad2"} {
+		os.WriteFile(filepath.Join(root, name), []byte(name), 0o644)
+	}
+	openFile = func(name string) (*os.File, error) {
+		if strings.HasPrefix(filepath.Base(name), "bad") {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+		}
+		return os.Open(name)
+	}
+	workers := workersNum
+	workersNum = 2
+	t.Cleanup(func() { openFile, workersNum = os.Open, workers })
+
+	sink, stats, errs := &collected{}, newScanStats(), &errorLog{}
+	run(root, []indexSink{sink}, stats, errs)
+	slices.Sort(sink.paths)
+	if want := []string{filepath.Join(root, "a"), filepath.Join(root, "sub/b")}; !slices.Equal(sink.paths, want) || !sink.closed {
+		t.Fatalf("indexed %v, closed %t", sink.paths, sink.closed)
+	}
+	if stats.files.Load() != 4 || stats.filesDone.Load() != 4 || stats.dirs.Load() != 2 {
+		t.Fatalf("found %d files, processed %d, %d directories", stats.files.Load(), stats.filesDone.Load(), stats.dirs.Load())
+	}
+	if stats.bytesDone.Load() != int64(len("a")+len("sub/b")) {
+		t.Fatalf("hashed %d bytes", stats.bytesDone.Load())
+	}
+	if errs.len() != 2 {
+		t.Fatalf("errors %v", errs.errs)
+	}
+	for _, e := range errs.errs {
+		if e.Stage != "hash" || !errors.Is(e.Err, os.ErrPermission) {
+			t.Errorf("error %+v", e)
+		}
+	}
+
+	var out bytes.Buffer
+	stats.summary(&out, errs, "")
+	if !strings.Contains(out.String(), "errors: 2\n") || !strings.Contains(out.String(), filepath.Join(root, "sub/bad2")+"\thash\t") {
+		t.Errorf("summary without an error file:\n%s", out.String())
+	}
+	errPath := filepath.Join(t.TempDir(), "index.errors")
+	if err := errs.save(errPath); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	stats.summary(&out, errs, errPath)
+	listed, _ := os.ReadFile(errPath)
+	if !strings.Contains(out.String(), "listed in "+errPath) || strings.Count(string(listed), "\n") != 3 {
+		t.Errorf("summary:\n%s\nerror file:\n%s", out.String(), listed)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// fileID identifies an inode across the whole scan.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+func idOf(info fs.FileInfo) (fileID, uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, 0, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: st.Ino}, uint64(st.Nlink), true
+}
+
+// statID follows symlinks, it is used for directories the walker is about to enter.
+func statID(path string) (fileID, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileID{}, err
+	}
+	id, _, _ := idOf(info)
+	return id, nil
+}
+
+// fileKind names the type of a non directory entry. Anything but "file" and "symlink" is never opened: reading a FIFO blocks and devices have no meaningful checksum.
+func fileKind(mode fs.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return "file"
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	case mode&fs.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	case mode&fs.ModeCharDevice != 0:
+		return "chardevice"
+	case mode&fs.ModeDevice != 0:
+		return "device"
+	default:
+		return "other"
+	}
+}
+
+// linkRegistry hands out the first path seen for every inode with more than one link, that path is the only one hashed.
+type linkRegistry struct {
+	mu      sync.Mutex
+	leaders map[fileID]string
+}
+
+func newLinkRegistry() *linkRegistry {
+	return &linkRegistry{leaders: make(map[fileID]string)}
+}
+
+// claim returns the leader of id's group and whether path just became it.
+func (r *linkRegistry) claim(id fileID, path string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if leader, ok := r.leaders[id]; ok {
+		return leader, false
+	}
+	r.leaders[id] = path
+	return path, true
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"regexp"
 	"sort"
+	"sync"
 
 	"github.com/pydpll/errorutils"
 	"github.com/sirupsen/logrus"
@@ -13,31 +14,85 @@ import (
 )
 
 var (
-	Version    string
-	Revision   = ".0"
-	CommitId   string
-	ignorable  []*regexp.Regexp
-	workersNum int64
+	Version        string
+	Revision       = ".0"
+	CommitId       string
+	ignorable      []*regexp.Regexp
+	workersNum     int64
+	typesEnabled   bool
+	oneFileSystem  bool
+	followSymlinks bool
 )
 
 func main() {
 	sort.Sort(cli.FlagsByName(appFlags))
 
 	app := &cli.Command{
-		Name:    "indexFiles",
-		Usage:   "recursive, parallel sha1sum for files and symlinks in directory",
-		Flags:   appFlags,
-		Version: fmt.Sprintf("%s%s (%s)", Version, Revision, CommitId),
-		Action: func(ctx context.Context, cmd *cli.Command) error {
-			run(cmd.String("examine"), cmd.String("output"))
-			return nil
-		},
+		Name:     "indexFiles",
+		Usage:    "recursive, parallel sha1sum for files and symlinks in directory",
+		Flags:    appFlags,
+		Commands: []*cli.Command{queryCmd},
+		Version:  fmt.Sprintf("%s%s (%s)", Version, Revision, CommitId),
+		Action:   index,
 	}
 
 	err := app.Run(context.Background(), os.Args)
 	errorutils.WarnOnFail(err, errorutils.WithMsg("app failed execution"))
 }
 
+func index(ctx context.Context, cmd *cli.Command) error {
+	dirPath, outputPath, dbPath := cmd.String("examine"), cmd.String("output"), cmd.String("db")
+	if dirPath == "" {
+		return errorutils.NewReport("a DIR to examine is required", "")
+	}
+	if outputPath == "" && dbPath == "" && !cmd.Bool("summary") {
+		return errorutils.NewReport("nowhere to save the index, use --output, --db and/or --summary", "")
+	}
+	var sinks []indexSink
+	if outputPath != "" {
+		s, err := newTSVSink(outputPath)
+		errorutils.ExitOnFail(err, errorutils.WithMsg(fmt.Sprintf("Error opening target file %s", outputPath)))
+		sinks = append(sinks, s)
+	}
+	if dbPath != "" {
+		vol, err := newVolume(cmd.String("volume"), dirPath)
+		errorutils.ExitOnFail(err, errorutils.WithMsg("could not collect volume metadata for "+dirPath))
+		s, err := newSQLiteSink(dbPath, vol)
+		errorutils.ExitOnFail(err, errorutils.WithMsg(fmt.Sprintf("Error opening database %s", dbPath)))
+		sinks = append(sinks, s)
+	}
+	// without a file to put it next to, the error list is printed with the summary
+	errPath := cmd.String("errors")
+	if errPath == "" && outputPath != "" {
+		errPath = outputPath + ".errors"
+	} else if errPath == "" && dbPath != "" {
+		errPath = dbPath + ".errors"
+	}
+
+	stats, errs := newScanStats(), &errorLog{}
+	stop := make(chan struct{})
+	var progressDone sync.WaitGroup
+	if fi, _ := os.Stderr.Stat(); !cmd.Bool("quiet") && fi.Mode()&os.ModeCharDevice != 0 {
+		progressDone.Add(1)
+		go func() {
+			defer progressDone.Done()
+			showProgress(os.Stderr, stats, errs, stop)
+		}()
+	}
+	if cmd.Bool("summary") {
+		sinks = append(sinks, newSummarySink(dirPath, int(cmd.Int("summary-depth"))))
+	}
+	run(dirPath, sinks, stats, errs)
+	close(stop)
+	progressDone.Wait()
+
+	if errPath != "" {
+		errorutils.WarnOnFail(errs.save(errPath), errorutils.WithMsg("could not save the error list to "+errPath))
+	}
+	stats.summary(os.Stderr, errs, errPath)
+	return nil
+}
+
 var appFlags []cli.Flag = []cli.Flag{
 	&cli.BoolFlag{
 		Name:    "debug",
@@ -51,16 +106,62 @@ var appFlags []cli.Flag = []cli.Flag{
 		},
 	},
 	&cli.StringFlag{
-		Name:     "examine",
-		Aliases:  []string{"e", "i"}, // input
-		Usage:    "`DIR` to examine",
-		Required: true,
+		Name:    "examine",
+		Aliases: []string{"e", "i"}, // input
+		Usage:   "`DIR` to examine",
+	},
+	&cli.StringFlag{
+		Name:    "output",
+		Aliases: []string{"o"},
+		Usage:   "`FILE` where the index should be saved to as TSV",
+	},
+	&cli.StringFlag{
+		Name:  "db",
+		Usage: "sqlite `FILE` collecting the index of every scanned volume, searchable with the query subcommand",
+	},
+	&cli.StringFlag{
+		Name:  "errors",
+		Usage: "`FILE` listing paths that could not be indexed. default: <output or db>.errors, printed with the summary when there is neither",
+	},
+	&cli.BoolFlag{
+		Name:    "quiet",
+		Aliases: []string{"q"},
+		Usage:   "no progress display while indexing",
+	},
+	&cli.BoolFlag{
+		Name:        "types",
+		Aliases:     []string{"t"},
+		Usage:       "adds the kwiqExt file category (MEDIA, ARCHIVE, BIOINFO, ...) of every file to the index",
+		Destination: &typesEnabled,
+	},
+	&cli.BoolFlag{
+		Name:  "summary",
+		Usage: "prints file counts and total bytes per category and directory when the scan ends, implies --types",
+		Action: func(ctx context.Context, cmd *cli.Command, summary bool) error {
+			typesEnabled = typesEnabled || summary
+			return nil
+		},
+	},
+	&cli.IntFlag{
+		Name:  "summary-depth",
+		Usage: "directory levels below DIR the summary is broken down into, -1 keeps every directory",
+		Value: 1,
+	},
+	&cli.BoolFlag{
+		Name:        "one-file-system",
+		Aliases:     []string{"X"},
+		Usage:       "do not descend into directories on other filesystems than DIR",
+		Destination: &oneFileSystem,
+	},
+	&cli.BoolFlag{
+		Name:        "follow-symlinks",
+		Aliases:     []string{"L"},
+		Usage:       "descend into symlinked directories, each directory is walked once so loops are safe",
+		Destination: &followSymlinks,
 	},
 	&cli.StringFlag{
-		Name:     "output",
-		Aliases:  []string{"o"},
-		Usage:    "`FILE` where the index should be saved to",
-		Required: true,
+		Name:  "volume",
+		Usage: "`NAME` of the volume table in --db. default: base name of the mount point",
 	},
 	&cli.StringFlag{
 		Name:    "ignoreRegexes",
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// scanStats is shared by the walker and the workers, fields are only touched atomically.
+type scanStats struct {
+	start      time.Time
+	dirs       atomic.Int64
+	files      atomic.Int64 // found by the walker
+	filesDone  atomic.Int64 // processed by the workers
+	symlinks   atomic.Int64
+	skipped    atomic.Int64
+	bytesTotal atomic.Int64 // bytes the walker expects to be hashed
+	bytesDone  atomic.Int64
+	specials   atomic.Int64
+	hardlinks  atomic.Int64 // paths after the first one of their inode
+	crossings  atomic.Int64 // directories skipped for being on another filesystem
+	revisits   atomic.Int64 // directories reached again through symlinks
+	walkDone   atomic.Bool
+
+	seenLinks map[fileID]bool // only touched by the walker
+}
+
+func newScanStats() *scanStats {
+	return &scanStats{start: time.Now(), seenLinks: make(map[fileID]bool)}
+}
+
+func (s *scanStats) found(entry fs.DirEntry) {
+	s.files.Add(1)
+	if !entry.Type().IsRegular() {
+		return
+	}
+	info, err := entry.Info()
+	if err != nil || info.Size() > maxHashSize {
+		return
+	}
+	if id, nlink, ok := idOf(info); ok && nlink > 1 {
+		if s.seenLinks[id] {
+			return
+		}
+		s.seenLinks[id] = true
+	}
+	s.bytesTotal.Add(info.Size())
+}
+
+// done counts a processed file, one that failed adds nothing to the bytes hashed.
+func (s *scanStats) done(info FileInfo, err error) {
+	s.filesDone.Add(1)
+	switch {
+	case err != nil && !info.IsSym:
+	case info.IsSym:
+		s.symlinks.Add(1)
+	case info.Path != "" && !info.Mode.IsRegular():
+		s.specials.Add(1)
+	case info.LinkOf != "":
+		s.hardlinks.Add(1)
+	case info.Size > maxHashSize:
+		s.skipped.Add(1)
+	default:
+		s.bytesDone.Add(info.Size)
+	}
+}
+
+// line renders the one line progress display: counts, rate and, once the walk is over, an ETA.
+func (s *scanStats) line(errCount int) string {
+	elapsed := time.Since(s.start)
+	done, total := s.bytesDone.Load(), s.bytesTotal.Load()
+	rate := float64(done) / elapsed.Seconds()
+	eta := "walking"
+	if s.walkDone.Load() {
+		eta = "ETA --"
+		if rate > 0 {
+			eta = "ETA " + (time.Duration(float64(total-done)/rate) * time.Second).Round(time.Second).String()
+		}
+	}
+	return fmt.Sprintf("%d/%d files | %s/%s | %s/s | %s | %d errors",
+		s.filesDone.Load(), s.files.Load(), humanBytes(done), humanBytes(total), humanBytes(int64(rate)), eta, errCount)
+}
+
+// showProgress redraws the progress line on w until stop is closed.
+func showProgress(w io.Writer, s *scanStats, errs *errorLog, stop <-chan struct{}) {
+	tick := time.NewTicker(500 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			fmt.Fprint(w, "\r\x1b[K")
+			return
+		case <-tick.C:
+			fmt.Fprintf(w, "\r\x1b[K%s", s.line(errs.len()))
+		}
+	}
+}
+
+func (s *scanStats) summary(w io.Writer, errs *errorLog, errPath string) {
+	elapsed := time.Since(s.start).Round(time.Millisecond)
+	fmt.Fprintf(w, "indexed %d files in %d directories in %s\n", s.filesDone.Load(), s.dirs.Load(), elapsed)
+	fmt.Fprintf(w, "\thashed %s (%s/s)\n", humanBytes(s.bytesDone.Load()), humanBytes(int64(float64(s.bytesDone.Load())/elapsed.Seconds())))
+	fmt.Fprintf(w, "\tsymlinks: %d, skipped for size: %d, special files: %d, extra hardlinks: %d\n", s.symlinks.Load(), s.skipped.Load(), s.specials.Load(), s.hardlinks.Load())
+	if n, m := s.crossings.Load(), s.revisits.Load(); n > 0 || m > 0 {
+		fmt.Fprintf(w, "\tdirectories on other filesystems: %d, already walked: %d\n", n, m)
+	}
+	switch n := errs.len(); {
+	case n == 0:
+		fmt.Fprintln(w, "\terrors: 0")
+	case errPath != "":
+		fmt.Fprintf(w, "\terrors: %d, listed in %s\n", n, errPath)
+	default:
+		fmt.Fprintf(w, "\terrors: %d\n", n)
+		errs.mu.Lock()
+		defer errs.mu.Unlock()
+		for _, e := range errs.errs {
+			fmt.Fprintf(w, "\t\t%s\t%s\t%v\n", e.Path, e.Stage, e.Err)
+		}
+	}
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+type scanError struct {
+	Path  string
+	Stage string // walk, hash, write or close
+	Err   error
+}
+
+// errorLog collects per-file failures so a single bad file never stops the scan.
+type errorLog struct {
+	mu   sync.Mutex
+	errs []scanError
+}
+
+func (l *errorLog) add(path, stage string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errs = append(l.errs, scanError{Path: path, Stage: stage, Err: err})
+}
+
+func (l *errorLog) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.errs)
+}
+
+// save writes the collected errors as TSV, nothing is created when the scan was clean.
+func (l *errorLog) save(path string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.errs) == 0 {
+		return nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "Path\tstage\terror")
+	for _, e := range l.errs {
+		fmt.Fprintf(w, "%s\t%s\t%v\n", e.Path, e.Stage, e.Err)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
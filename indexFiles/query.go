@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pydpll/errorutils"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v3"
+)
+
+var queryCmd = &cli.Command{
+	Name:  "query",
+	Usage: "search the catalog of every volume indexed into a database",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "db",
+			Usage:    "sqlite `FILE` written with --db",
+			Required: true,
+		},
+		&cli.StringSliceFlag{
+			Name:    "volume",
+			Aliases: []string{"v"},
+			Usage:   "restrict results to `NAME`, can be repeated",
+		},
+	},
+	Commands: []*cli.Command{
+		{
+			Name:      "copies",
+			Usage:     "where do copies of this hash live",
+			ArgsUsage: "HASH|FILE",
+			Action:    queryCopies,
+		},
+		{
+			Name:      "under",
+			Usage:     "files under a path",
+			ArgsUsage: "PATH",
+			Action:    queryUnder,
+		},
+		{
+			Name:   "largest",
+			Usage:  "largest files across volumes",
+			Action: queryLargest,
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:    "number",
+					Aliases: []string{"n"},
+					Usage:   "how many files to list",
+					Value:   20,
+				},
+			},
+		},
+		{
+			Name:   "types",
+			Usage:  "file counts and total bytes per category and directory, needs volumes indexed with --types",
+			Action: queryTypes,
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:  "depth",
+					Usage: "directory levels below the volume root the summary is broken down into, -1 keeps every directory",
+					Value: 1,
+				},
+			},
+		},
+		{
+			Name:   "volumes",
+			Usage:  "list indexed volumes and their scan metadata",
+			Action: queryVolumes,
+		},
+	},
+}
+
+func queryCopies(ctx context.Context, cmd *cli.Command) error {
+	target := cmd.Args().First()
+	if target == "" {
+		return errorutils.NewReport("copies needs a HASH or FILE", "")
+	}
+	where, args, err := copiesWhere(target)
+	if err != nil {
+		return err
+	}
+	where, args = withHardlinks(where, args)
+	return runQuery(cmd, where, "path", args...)
+}
+
+// withHardlinks extends where to the other links of the files it matches, they were indexed as HARDLINK:<first path> without a hash.
+func withHardlinks(where string, args []any) (string, []any) {
+	return fmt.Sprintf("(%s) OR link_of IN (SELECT path FROM {table} WHERE %s)", where, where), append(append([]any{}, args...), args...)
+}
+
+var sha1Hex = regexp.MustCompile("^[0-9a-fA-F]{40}$")
+
+// copiesWhere matches a hash, or the checksum of a FILE. Files too big to be hashed were indexed without one,
+// their copies are the skipped files of the same size and name.
+func copiesWhere(target string) (string, []any, error) {
+	info, err := os.Stat(target)
+	if err != nil && sha1Hex.MatchString(target) {
+		return "identity = ?", []any{strings.ToLower(target)}, nil
+	}
+	if err != nil {
+		return "", nil, errorutils.NewReport(target+" is neither a file nor a sha1 hash", "", errorutils.WithInner(err))
+	}
+	if !info.Mode().IsRegular() {
+		return "", nil, errorutils.NewReport(target+" is not a regular file", "")
+	}
+	if info.Size() > maxHashSize {
+		logrus.Infof("%s is over %s and was never hashed, matching files of the same size and name", target, humanBytes(maxHashSize))
+		name := "/" + filepath.Base(target)
+		return "identity = ? AND size = ? AND substr(path, -length(?)) = ?", []any{skippedIdentity, info.Size(), name, name}, nil
+	}
+	buf := make([]byte, 1024*1024)
+	hash, err := checksum(target, &buf)
+	if err != nil {
+		return "", nil, err
+	}
+	return "identity = ?", []any{hash}, nil
+}
+
+func queryUnder(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Args().First() == "" {
+		return errorutils.NewReport("under needs a PATH", "")
+	}
+	where, args, err := underWhere(cmd.Args().First())
+	if err != nil {
+		return err
+	}
+	return runQuery(cmd, where, "path", args...)
+}
+
+func underWhere(path string) (string, []any, error) {
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return "", nil, err
+	}
+	// '0' sorts right after '/', the range covers everything below dir and stays on the primary key
+	prefix := strings.TrimSuffix(dir, "/")
+	return "path = ? OR (path >= ? AND path < ?)", []any{dir, prefix + "/", prefix + "0"}, nil
+}
+
+// largestWhere leaves out the other links of a file, they share its bytes, and special files, their sizes are not bytes on disk.
+const largestWhere = "kind = 'file' AND link_of = ''"
+
+func queryLargest(ctx context.Context, cmd *cli.Command) error {
+	return runQuery(cmd, largestWhere, fmt.Sprintf("size DESC LIMIT %d", cmd.Int("number")))
+}
+
+func queryVolumes(ctx context.Context, cmd *cli.Command) error {
+	db, vols, err := selectedVolumes(cmd)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	fmt.Fprintln(w, "Volume\tHost\tMount\tRoot\tScanned\tFiles")
+	for _, v := range vols {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n", v.Name, v.Host, v.Mount, v.Root, v.Scanned.Format(time.DateTime), v.Files)
+	}
+	return nil
+}
+
+func queryTypes(ctx context.Context, cmd *cli.Command) error {
+	db, vols, err := selectedVolumes(cmd)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	fmt.Fprintln(w, "Volume\tDirectory\tCategory\tFiles\tBytes")
+	for _, v := range vols {
+		summary, err := volumeTypes(db, v, int(cmd.Int("depth")))
+		if err != nil {
+			return err
+		}
+		if err := summary.write(w, v.Name+"\t"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// volumeTypes summarizes the categories of a volume. Tables written before categories were indexed have no column
+// for them, volumes indexed without --types have it empty on every row.
+func volumeTypes(db *sql.DB, v Volume, depth int) (*typeSummary, error) {
+	noCategories := errorutils.NewReport(fmt.Sprintf("volume %s was indexed without categories, re-index it with --types", v.Name), "")
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM pragma_table_info(?) WHERE name = 'category'", v.Table).Scan(&n); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, noCategories
+	}
+	var files, categorized int
+	if err := db.QueryRow(fmt.Sprintf(`SELECT count(*), count(NULLIF(category, '')) FROM "%s" WHERE symlink = 0`, v.Table)).Scan(&files, &categorized); err != nil {
+		return nil, err
+	}
+	if files > 0 && categorized == 0 {
+		return nil, noCategories
+	}
+	summary := newTypeSummary(v.Root, depth)
+	rows, err := db.Query(fmt.Sprintf(`SELECT path, category, size FROM "%s" WHERE symlink = 0`, v.Table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var path, category string
+		var size int64
+		if err := rows.Scan(&path, &category, &size); err != nil {
+			return nil, err
+		}
+		summary.add(path, category, size)
+	}
+	return summary, rows.Err()
+}
+
+// runQuery applies the same condition to every selected volume table and prints the union as TSV.
+func runQuery(cmd *cli.Command, where, order string, args ...any) error {
+	db, vols, err := selectedVolumes(cmd)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if len(vols) == 0 {
+		return errorutils.NewReport("no volumes indexed in "+cmd.String("db"), "")
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	return printQuery(w, db, vols, where, order, args...)
+}
+
+// printQuery names the table of each volume {table} in where.
+func printQuery(w io.Writer, db *sql.DB, vols []Volume, where, order string, args ...any) error {
+	hosts := make(map[string]string, len(vols))
+	parts := make([]string, 0, len(vols))
+	var allArgs []any
+	for _, v := range vols {
+		hosts[v.Name] = v.Host
+		table := `"` + v.Table + `"`
+		parts = append(parts, fmt.Sprintf(`SELECT ? AS volume, path, identity, symlink, size FROM %s WHERE %s`, table, strings.ReplaceAll(where, "{table}", table)))
+		allArgs = append(allArgs, v.Name)
+		allArgs = append(allArgs, args...)
+	}
+	q := fmt.Sprintf("SELECT volume, path, identity, symlink, size FROM (%s) ORDER BY %s", strings.Join(parts, " UNION ALL "), order)
+	rows, err := db.Query(q, allArgs...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	fmt.Fprintln(w, "Volume\tHost\tPath\tidentity\tSymlink\tSize")
+	for rows.Next() {
+		var (
+			vol, path, identity string
+			isSym               bool
+			size                int64
+		)
+		if err := rows.Scan(&vol, &path, &identity, &isSym, &size); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%d\n", vol, hosts[vol], path, identity, isSym, size)
+	}
+	return rows.Err()
+}
+
+func selectedVolumes(cmd *cli.Command) (*sql.DB, []Volume, error) {
+	dbPath := cmd.String("db")
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, nil, err
+	}
+	db, err := openIndexDB(dbPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	wanted := make(map[string]bool)
+	for _, name := range cmd.StringSlice("volume") {
+		wanted[name] = true
+	}
+	rows, err := db.Query("SELECT name, tbl, host, mount, root, scanned_at, files FROM volumes ORDER BY name")
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var vols []Volume
+	for rows.Next() {
+		var v Volume
+		var scanned string
+		if err := rows.Scan(&v.Name, &v.Table, &v.Host, &v.Mount, &v.Root, &scanned, &v.Files); err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		v.Scanned, _ = time.Parse(time.RFC3339, scanned)
+		if len(wanted) > 0 && !wanted[v.Name] {
+			continue
+		}
+		vols = append(vols, v)
+	}
+	if err := rows.Err(); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return db, vols, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// catalog indexes rows into a fresh database as volume "disk".
+func catalog(t *testing.T, rows ...FileInfo) (string, Volume) {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), "index.db")
+	vol := Volume{Name: "disk", Table: volumeTable("disk"), Host: "host", Mount: "/", Root: "/"}
+	s, err := newSQLiteSink(dbPath, vol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := s.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return dbPath, vol
+}
+
+// matches runs where against the catalog of rows and returns the paths found.
+func matches(t *testing.T, where string, args []any, rows ...FileInfo) []string {
+	t.Helper()
+	dbPath, vol := catalog(t, rows...)
+	db, err := openIndexDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var out bytes.Buffer
+	if err := printQuery(&out, db, []Volume{vol}, where, "path", args...); err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n")[1:] {
+		paths = append(paths, strings.Split(line, "\t")[2])
+	}
+	return paths
+}
+
+func TestQueryUnder(t *testing.T) {
+	rows := []FileInfo{{Path: "/data/a"}, {Path: "/data/a/x"}, {Path: "/data/a/b/y"}, {Path: "/data/a.txt"}, {Path: "/data/a0"}, {Path: "/data/ab/z"}}
+	for _, dir := range []string{"/data/a", "/data/a/", "/data/./a"} {
+		where, args, err := underWhere(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := matches(t, where, args, rows...); !slices.Equal(got, []string{"/data/a", "/data/a/b/y", "/data/a/x"}) {
+			t.Errorf("under %s: %v", dir, got)
+		}
+	}
+}
+
+func TestQueryCopies(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "notes.txt")
+	os.WriteFile(file, []byte("the same bytes"), 0o644)
+	buf := make([]byte, 1024)
+	hash, _ := checksum(file, &buf)
+
+	where, args, err := copiesWhere(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := []FileInfo{
+		{Path: "/a/notes.txt", Identity: hash},
+		{Path: "/b/renamed", Identity: hash},
+		{Path: "/c/notes.txt", Identity: "0123"},
+		{Path: "/d/linked", Identity: "HARDLINK:/a/notes.txt", LinkOf: "/a/notes.txt"},
+		{Path: "/e/linked", Identity: "HARDLINK:/c/notes.txt", LinkOf: "/c/notes.txt"},
+	}
+	if got := matches(t, where, args, rows...); !slices.Equal(got, []string{"/a/notes.txt", "/b/renamed"}) {
+		t.Errorf("copies of a file: %v", got)
+	}
+	where, args = withHardlinks(where, args)
+	if got := matches(t, where, args, rows...); !slices.Equal(got, []string{"/a/notes.txt", "/b/renamed", "/d/linked"}) {
+		t.Errorf("copies of a file with hardlinks: %v", got)
+	}
+	where, args, _ = copiesWhere(strings.ToUpper(hash))
+	if got := matches(t, where, args, rows...); len(got) != 2 {
+		t.Errorf("copies of a hash: %v", got)
+	}
+	for _, bad := range []string{filepath.Join(dir, "notes.tx"), "0123", dir} {
+		if _, _, err := copiesWhere(bad); err == nil {
+			t.Errorf("copies of %s: no error", bad)
+		}
+	}
+
+	// too big to be hashed, the copies are the skipped files of the same size and name
+	big := filepath.Join(dir, "disk.iso")
+	os.WriteFile(big, nil, 0o644)
+	if err := os.Truncate(big, maxHashSize+1); err != nil {
+		t.Fatal(err)
+	}
+	where, args, err = copiesWhere(big)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(maxHashSize + 1)
+	rows = []FileInfo{
+		{Path: "/backup/disk.iso", Identity: skippedIdentity, Size: size},
+		{Path: "/backup/other.iso", Identity: skippedIdentity, Size: size},
+		{Path: "/backup/olddisk.iso", Identity: skippedIdentity, Size: size},
+		{Path: "/old/disk.iso", Identity: skippedIdentity, Size: size - 1},
+		{Path: "/usb/disk.iso", Identity: skippedIdentity, Size: size},
+	}
+	if got := matches(t, where, args, rows...); !slices.Equal(got, []string{"/backup/disk.iso", "/usb/disk.iso"}) {
+		t.Errorf("copies of a big file: %v", got)
+	}
+}
+
+func TestQueryLargest(t *testing.T) {
+	rows := []FileInfo{
+		{Path: "/big", Identity: "aa", Size: 300},
+		{Path: "/big.link", Identity: "HARDLINK:/big", LinkOf: "/big", Size: 300},
+		{Path: "/dev/sda", Identity: "SPECIAL:device", Mode: fs.ModeDevice, Size: 1 << 40},
+		{Path: "/small", Identity: "bb", Size: 1},
+	}
+	if got := matches(t, largestWhere, nil, rows...); !slices.Equal(got, []string{"/big", "/small"}) {
+		t.Errorf("largest %v", got)
+	}
+}
+
+func TestQueryTypes(t *testing.T) {
+	dir := t.TempDir()
+	var rows, untyped []FileInfo
+	for name, content := range map[string]string{"report.pdf": "%PDF-1.4", "notes.txt": "some notes", "main.go": "package main"} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0o644)
+		info := FileInfo{Path: path, Size: int64(len(content))}
+		untyped = append(untyped, info)
+		if err := categorize(&info); err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, info)
+	}
+	dbPath, vol := catalog(t, rows...)
+	db, err := openIndexDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	summary, err := volumeTypes(db, vol, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, category := range []string{"PDF", "TXT", "SOURCE"} {
+		if c := summary.dirs["."][category]; c == nil || c.files != 1 {
+			t.Errorf("%s: %v", category, summary.dirs)
+		}
+	}
+
+	// a volume indexed without --types
+	dbPath, vol = catalog(t, untyped...)
+	db, err = openIndexDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := volumeTypes(db, vol, 0); err == nil || !strings.Contains(err.Error(), "re-index it with --types") {
+		t.Fatalf("volume without categories: %v", err)
+	}
+
+	// a volume indexed before categories existed
+	old := Volume{Name: "old", Table: volumeTable("old")}
+	db.Exec(`CREATE TABLE "vol_old" (path TEXT PRIMARY KEY, identity TEXT NOT NULL, symlink INTEGER NOT NULL, size INTEGER NOT NULL)`)
+	if _, err := volumeTypes(db, old, -1); err == nil || !strings.Contains(err.Error(), "re-index it with --types") {
+		t.Fatalf("old volume: %v", err)
+	}
+}
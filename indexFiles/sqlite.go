@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"syscall"
+	"time"
+
+	"github.com/pydpll/errorutils"
+	_ "modernc.org/sqlite"
+)
+
+// Volume holds the scan metadata stored next to each volume table.
+type Volume struct {
+	Name    string
+	Table   string
+	Host    string
+	Mount   string
+	Root    string
+	Scanned time.Time
+	Files   int64
+}
+
+const volumesSchema = `CREATE TABLE IF NOT EXISTS volumes (
+	name       TEXT PRIMARY KEY,
+	tbl        TEXT NOT NULL UNIQUE,
+	host       TEXT NOT NULL,
+	mount      TEXT NOT NULL,
+	root       TEXT NOT NULL,
+	scanned_at TEXT NOT NULL,
+	files      INTEGER NOT NULL
+)`
+
+var nonIdent = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+func volumeTable(name string) string {
+	return "vol_" + nonIdent.ReplaceAllString(name, "_")
+}
+
+// newVolume gathers host and mount point for the scanned directory. An empty name defaults to the base name of the mount point.
+func newVolume(name, dirPath string) (Volume, error) {
+	root, err := filepath.Abs(dirPath)
+	if err != nil {
+		return Volume{}, err
+	}
+	mount, err := mountPoint(root)
+	if err != nil {
+		return Volume{}, err
+	}
+	host, err := os.Hostname()
+	errorutils.WarnOnFail(err, errorutils.WithMsg("could not determine hostname"))
+	if name == "" {
+		name = filepath.Base(mount)
+		if name == string(filepath.Separator) {
+			name = "root"
+		}
+	}
+	return Volume{
+		Name:    name,
+		Table:   volumeTable(name),
+		Host:    host,
+		Mount:   mount,
+		Root:    root,
+		Scanned: time.Now(),
+	}, nil
+}
+
+// mountPoint climbs from path until the parent lives on a different device.
+func mountPoint(path string) (string, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return "", err
+	}
+	dev := st.Dev
+	for {
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path, nil
+		}
+		if err := syscall.Stat(parent, &st); err != nil {
+			return "", err
+		}
+		if st.Dev != dev {
+			return path, nil
+		}
+		path = parent
+	}
+}
+
+func openIndexDB(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(volumesSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not prepare %s: %w", dbPath, err)
+	}
+	return db, nil
+}
+
+// sqliteSink replaces the volume table on every scan. Rows go in a single transaction so an interrupted scan leaves the previous catalog intact.
+type sqliteSink struct {
+	db   *sql.DB
+	tx   *sql.Tx
+	stmt *sql.Stmt
+	vol  Volume
+}
+
+func newSQLiteSink(dbPath string, vol Volume) (*sqliteSink, error) {
+	db, err := openIndexDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	var owner string
+	err = db.QueryRow("SELECT name FROM volumes WHERE tbl = ? AND name != ?", vol.Table, vol.Name).Scan(&owner)
+	if err == nil {
+		db.Close()
+		return nil, fmt.Errorf("volume name %q clashes with existing volume %q, choose another with --volume", vol.Name, owner)
+	} else if err != sql.ErrNoRows {
+		db.Close()
+		return nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &sqliteSink{db: db, tx: tx, vol: vol}
+	for _, q := range []string{
+		fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, vol.Table),
+		fmt.Sprintf(`CREATE TABLE "%s" (path TEXT PRIMARY KEY, identity TEXT NOT NULL, symlink INTEGER NOT NULL, size INTEGER NOT NULL, category TEXT NOT NULL, kind TEXT NOT NULL, dev INTEGER NOT NULL, inode INTEGER NOT NULL, link_of TEXT NOT NULL)`, vol.Table),
+		fmt.Sprintf(`CREATE INDEX "%s_identity" ON "%s" (identity)`, vol.Table, vol.Table),
+		fmt.Sprintf(`CREATE INDEX "%s_size" ON "%s" (size)`, vol.Table, vol.Table),
+	} {
+		if _, err := tx.Exec(q); err != nil {
+			s.abort()
+			return nil, err
+		}
+	}
+	s.stmt, err = tx.Prepare(fmt.Sprintf(`INSERT OR REPLACE INTO "%s" (path, identity, symlink, size, category, kind, dev, inode, link_of) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, vol.Table))
+	if err != nil {
+		s.abort()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *sqliteSink) Write(info FileInfo) error {
+	abs, err := filepath.Abs(info.Path)
+	if err != nil {
+		return err
+	}
+	if _, err := s.stmt.Exec(abs, info.Identity, info.IsSym, info.Size, info.Category, fileKind(info.Mode), int64(info.ID.dev), int64(info.ID.ino), info.LinkOf); err != nil {
+		return err
+	}
+	s.vol.Files++
+	return nil
+}
+
+func (s *sqliteSink) Close() error {
+	defer s.db.Close()
+	s.stmt.Close()
+	_, err := s.tx.Exec(`INSERT OR REPLACE INTO volumes (name, tbl, host, mount, root, scanned_at, files) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		s.vol.Name, s.vol.Table, s.vol.Host, s.vol.Mount, s.vol.Root, s.vol.Scanned.Format(time.RFC3339), s.vol.Files)
+	if err != nil {
+		s.tx.Rollback()
+		return err
+	}
+	return s.tx.Commit()
+}
+
+func (s *sqliteSink) abort() {
+	if s.stmt != nil {
+		s.stmt.Close()
+	}
+	s.tx.Rollback()
+	s.db.Close()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	filetyper "github.com/jmonroynieto/cliWorkflow_tk/kwiqExt/filetyper"
+)
+
+// categorize fills the kwiqExt category of a regular file, symlinks and special files are left without one.
+func categorize(info *FileInfo) error {
+	if !info.Mode.IsRegular() {
+		return nil
+	}
+	ft, err := filetyper.DetermineFMTtype(info.Path)
+	info.Category = ft.String()
+	return err
+}
+
+type categoryCount struct {
+	files int64
+	bytes int64
+}
+
+// typeSummary rolls file counts and bytes per category up to the directories depth levels below root. A negative depth keeps every directory.
+type typeSummary struct {
+	root  string
+	depth int
+	dirs  map[string]map[string]*categoryCount
+}
+
+func newTypeSummary(root string, depth int) *typeSummary {
+	return &typeSummary{root: root, depth: depth, dirs: make(map[string]map[string]*categoryCount)}
+}
+
+func (t *typeSummary) add(path, category string, size int64) {
+	dir, err := filepath.Rel(t.root, filepath.Dir(path))
+	if err != nil || strings.HasPrefix(dir, "..") {
+		dir = filepath.Dir(path)
+	} else if t.depth >= 0 {
+		parts := strings.Split(dir, string(filepath.Separator))
+		if len(parts) > t.depth {
+			parts = parts[:t.depth]
+		}
+		dir = filepath.Join(append([]string{"."}, parts...)...)
+	}
+	if category == "" {
+		category = filetyper.UNKNOWN.String()
+	}
+	cats, ok := t.dirs[dir]
+	if !ok {
+		cats = make(map[string]*categoryCount)
+		t.dirs[dir] = cats
+	}
+	c, ok := cats[category]
+	if !ok {
+		c = &categoryCount{}
+		cats[category] = c
+	}
+	c.files++
+	c.bytes += size
+}
+
+// write prints one TSV row per directory and category, biggest categories first, followed by the totals under directory "*". prefix is prepended to every row.
+func (t *typeSummary) write(w io.Writer, prefix string) error {
+	totals := make(map[string]*categoryCount)
+	dirs := make([]string, 0, len(t.dirs))
+	for dir, cats := range t.dirs {
+		dirs = append(dirs, dir)
+		for cat, c := range cats {
+			tot, ok := totals[cat]
+			if !ok {
+				tot = &categoryCount{}
+				totals[cat] = tot
+			}
+			tot.files += c.files
+			tot.bytes += c.bytes
+		}
+	}
+	slices.Sort(dirs)
+	for _, dir := range dirs {
+		if err := writeCategories(w, prefix+dir, t.dirs[dir]); err != nil {
+			return err
+		}
+	}
+	return writeCategories(w, prefix+"*", totals)
+}
+
+func writeCategories(w io.Writer, dir string, cats map[string]*categoryCount) error {
+	names := make([]string, 0, len(cats))
+	for cat := range cats {
+		names = append(names, cat)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		return cmp.Or(cmp.Compare(cats[b].bytes, cats[a].bytes), cmp.Compare(a, b))
+	})
+	for _, cat := range names {
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", dir, cat, cats[cat].files, cats[cat].bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// summarySink aggregates categories during the scan and prints the summary once it is closed.
+type summarySink struct {
+	summary *typeSummary
+	out     io.Writer
+}
+
+func newSummarySink(root string, depth int) *summarySink {
+	return &summarySink{summary: newTypeSummary(root, depth), out: os.Stdout}
+}
+
+func (s *summarySink) Write(info FileInfo) error {
+	if info.Mode.IsRegular() && info.LinkOf == "" {
+		s.summary.add(info.Path, info.Category, info.Size)
+	}
+	return nil
+}
+
+func (s *summarySink) Close() error {
+	if _, err := fmt.Fprintln(s.out, "Directory\tCategory\tFiles\tBytes"); err != nil {
+		return err
+	}
+	return s.summary.write(s.out, "")
+}
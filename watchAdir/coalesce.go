@@ -0,0 +1,164 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// net effects reported by the coalescer, chmod is a path that only changed its attributes.
+const (
+	created  = "created"
+	modified = "modified"
+	deleted  = "deleted"
+	renamed  = "renamed"
+	chmodded = "chmod"
+)
+
+// coalescer merges the events of a window, opened by the first event, in one net change per path. A Rename and the Create
+// right after it are a move when the watcher paired them, inotify reports both halves of a move back to back. Events of a
+// path that is created and removed within the window cancel out, as the temporary file of an editor save does.
+type coalescer struct {
+	window time.Duration
+	emit   func(event)
+
+	mu      sync.Mutex
+	paths   map[string]*pathState
+	order   []string
+	renamed string          // old path of the last event when it was a Rename
+	known   map[string]bool // paths that existed when the window opened, true for directories
+	timer   *time.Timer
+	flushMu sync.Mutex // one window is emitted at a time
+}
+
+type pathState struct {
+	existedBefore bool
+	existsNow     bool
+	changed       bool // more than attributes
+	from          string
+	moved         bool // the path is the old name of a move reported on its new name
+	isDir         bool
+}
+
+func newCoalescer(window time.Duration, emit func(event)) *coalescer {
+	return &coalescer{window: window, emit: emit, paths: make(map[string]*pathState), known: make(map[string]bool)}
+}
+
+// seed records the entries of dirs as existing, a Create replacing one of them is a modification.
+func (c *coalescer) seed(dirs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			c.known[filepath.Join(dir, entry.Name())] = entry.IsDir()
+		}
+	}
+}
+
+// add takes a single operation event as newEvents makes them.
+func (c *coalescer) add(e event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.paths[e.Path]
+	if s == nil {
+		s = &pathState{}
+		isDir, known := c.known[e.Path]
+		s.existedBefore = known || e.Op != "create"
+		s.isDir = isDir || e.IsDir
+		c.paths[e.Path] = s
+		c.order = append(c.order, e.Path)
+	}
+	if e.IsDir {
+		s.isDir = true
+	}
+	lastRename := c.renamed
+	c.renamed = ""
+	switch e.Op {
+	case "create":
+		s.existsNow, s.changed = true, true
+		if lastRename != "" && lastRename != e.Path && e.movedFrom == lastRename {
+			s.from = lastRename
+		}
+	case "write":
+		s.existsNow, s.changed = true, true
+	case "chmod":
+		s.existsNow = true
+	case "remove":
+		s.existsNow, s.changed, s.from = false, true, ""
+	case "rename":
+		s.existsNow, s.changed, s.from = false, true, ""
+		c.renamed = e.Path
+	}
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.window, c.flush)
+	}
+}
+
+// flush emits the net change of every path of the window.
+func (c *coalescer) flush() {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+	c.mu.Lock()
+	paths, order := c.paths, c.order
+	c.paths, c.order, c.renamed, c.timer = make(map[string]*pathState), nil, "", nil
+	for path, s := range paths {
+		if s.existsNow {
+			c.known[path] = s.isDir
+		} else {
+			delete(c.known, path)
+		}
+	}
+	c.mu.Unlock()
+
+	now := time.Now()
+	// a move is reported on the new name when the old one existed before and is gone,
+	// a file moved from a name created within the window is only created
+	for _, s := range paths {
+		if src := paths[s.from]; s.existsNow && src != nil {
+			if src.existedBefore && !src.existsNow {
+				src.moved = true
+			} else {
+				s.from = ""
+			}
+		}
+	}
+	for _, path := range order {
+		s := paths[path]
+		e := event{Time: now, Path: path, IsDir: s.isDir}
+		switch {
+		case s.moved:
+			continue
+		case s.existsNow && s.from != "":
+			e.Op, e.From = renamed, s.from
+		case s.existedBefore && s.existsNow && s.changed:
+			e.Op = modified
+		case s.existedBefore && s.existsNow:
+			e.Op = chmodded
+		case s.existedBefore:
+			e.Op = deleted
+		case s.existsNow:
+			e.Op = created
+		default:
+			continue
+		}
+		if info, err := os.Lstat(path); err == nil && s.existsNow {
+			e.Size, e.IsDir = info.Size(), info.IsDir()
+		}
+		c.emit(e)
+	}
+}
+
+// close emits what is left of the window.
+func (c *coalescer) close() {
+	c.mu.Lock()
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.mu.Unlock()
+	c.flush()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// coalesced feeds the operations, "op path" each, to a coalescer seeded with dir and returns what one window reports.
+// "create new<old" is a create the watcher paired with the rename of old.
+func coalesced(t *testing.T, dir string, ops ...string) []string {
+	t.Helper()
+	var got []string
+	c := newCoalescer(time.Hour, func(e event) {
+		line := e.Op + " " + filepath.Base(e.Path)
+		if e.From != "" {
+			line += " from " + filepath.Base(e.From)
+		}
+		got = append(got, line)
+	})
+	c.seed([]string{dir})
+	for _, op := range ops {
+		name, path, _ := strings.Cut(op, " ")
+		e := event{Op: name, Path: filepath.Join(dir, path)}
+		if path, from, moved := strings.Cut(path, "<"); moved {
+			e.Path, e.movedFrom = filepath.Join(dir, path), filepath.Join(dir, from)
+		}
+		c.add(e)
+	}
+	c.close()
+	return got
+}
+
+func TestCoalescer(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"existing.txt", "moved.txt"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o644)
+	}
+	for _, tc := range []struct {
+		name string
+		ops  []string
+		want string
+	}{
+		{"writes", []string{"write existing.txt", "write existing.txt", "chmod existing.txt"}, "modified existing.txt"},
+		{"new file", []string{"create new.txt", "write new.txt", "write new.txt"}, "created new.txt"},
+		{"temporary", []string{"create .tmp", "write .tmp", "remove .tmp"}, ""},
+		{"delete", []string{"write existing.txt", "remove existing.txt"}, "deleted existing.txt"},
+		{"chmod", []string{"chmod existing.txt"}, "chmod existing.txt"},
+		{"move", []string{"rename moved.txt", "create renamed.txt<moved.txt"}, "renamed renamed.txt from moved.txt"},
+		{"move between directories", []string{"rename moved.txt", "create sub/moved.txt<moved.txt"}, "renamed moved.txt from moved.txt"},
+		{"moved out", []string{"rename moved.txt"}, "deleted moved.txt"},
+		// mv moved.txt /elsewhere; touch other.txt
+		{"moved out, then a new file", []string{"rename moved.txt", "create other.txt", "chmod other.txt"}, "deleted moved.txt, created other.txt"},
+		{"not right after the rename", []string{"rename moved.txt", "write existing.txt", "create renamed.txt<moved.txt"}, "deleted moved.txt, modified existing.txt, created renamed.txt"},
+		// write a temporary file and rename it over the original
+		{"atomic save", []string{"create existing.txt.swp", "write existing.txt.swp", "rename existing.txt.swp", "create existing.txt<existing.txt.swp"}, "modified existing.txt"},
+		// vim keeps a backup by renaming the original and writes a new file
+		{"backup save", []string{"rename existing.txt", "create existing.txt~<existing.txt", "create existing.txt", "write existing.txt", "remove existing.txt~"}, "modified existing.txt"},
+	} {
+		if got := strings.Join(coalesced(t, dir, tc.ops...), ", "); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCoalescerWindows(t *testing.T) {
+	dir := t.TempDir()
+	got := make(chan event, 4)
+	c := newCoalescer(30*time.Millisecond, func(e event) { got <- e })
+	path := filepath.Join(dir, "out.txt")
+	os.WriteFile(path, []byte("hello"), 0o644)
+	c.add(event{Op: "create", Path: path})
+	c.add(event{Op: "write", Path: path})
+	select {
+	case e := <-got:
+		if e.Op != created || e.Size != 5 {
+			t.Fatalf("first window %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("window never closed")
+	}
+	// the path is known from now on, rewriting it is a modification
+	c.add(event{Op: "create", Path: path})
+	c.close()
+	if e := <-got; e.Op != modified {
+		t.Fatalf("second window %+v", e)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// event is one change as watchAdir prints it, an fsnotify operation or the net change of a coalesced window.
+type event struct {
+	Time  time.Time `json:"time"`
+	Op    string    `json:"event"`
+	Path  string    `json:"path"`
+	Size  int64     `json:"size"`
+	IsDir bool      `json:"is_dir"`
+	From  string    `json:"from,omitempty"` // old name of a rename
+
+	movedFrom string // the old name the watcher paired with a create, the coalescer reports the move
+}
+
+var opNames = []struct {
+	op   fsnotify.Op
+	name string
+}{
+	{fsnotify.Create, "create"},
+	{fsnotify.Write, "write"},
+	{fsnotify.Remove, "remove"},
+	{fsnotify.Rename, "rename"},
+	{fsnotify.Chmod, "chmod"},
+}
+
+const defaultEvents = "create,write,remove,rename"
+
+// parseOps reads a comma separated list of event names.
+func parseOps(list string) (map[string]bool, error) {
+	ops := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, o := range opNames {
+			if o.name == name {
+				ops[name] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no such event %q, possible values: create, write, remove, rename, chmod", name)
+		}
+	}
+	return ops, nil
+}
+
+// newEvents splits an fsnotify event in one event per operation, a path that is gone has no size.
+func newEvents(e fsnotify.Event, now time.Time) []event {
+	var size int64
+	isDir := false
+	if info, err := os.Lstat(e.Name); err == nil {
+		size, isDir = info.Size(), info.IsDir()
+	}
+	var events []event
+	for _, o := range opNames {
+		if e.Op.Has(o.op) {
+			events = append(events, event{Time: now, Op: o.name, Path: e.Name, Size: size, IsDir: isDir})
+		}
+	}
+	if e.Has(fsnotify.Create) {
+		events[0].movedFrom = renamedFrom(e)
+	}
+	return events
+}
+
+// renamedFrom is the old name inotify paired with a Create by the cookie of the move. fsnotify keeps it unexported
+// and only shows it in String, as `CREATE "new" ← "old"`.
+func renamedFrom(e fsnotify.Event) string {
+	prefix := fmt.Sprintf("%-13s %q ← ", e.Op.String(), e.Name)
+	rest, found := strings.CutPrefix(e.String(), prefix)
+	if !found {
+		return ""
+	}
+	from, err := strconv.Unquote(rest)
+	if err != nil {
+		return ""
+	}
+	return from
+}
+
+// filter keeps the events of the chosen kinds whose path matches an include glob, if any, and no exclude glob.
+// Globs without a slash are matched against the name, the others against the path relative to the root.
+type filter struct {
+	ops     map[string]bool
+	include []string
+	exclude []string
+}
+
+func newFilter(events string, include, exclude []string) (*filter, error) {
+	ops, err := parseOps(events)
+	if err != nil {
+		return nil, err
+	}
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("glob %q: %w", pattern, err)
+		}
+	}
+	return &filter{ops: ops, include: include, exclude: exclude}, nil
+}
+
+// netOps are the events choosing each net change.
+var netOps = map[string]string{created: "create", modified: "write", deleted: "remove", renamed: "rename", chmodded: "chmod"}
+
+func (f *filter) keep(root string, e event) bool {
+	op := e.Op
+	if raw, ok := netOps[op]; ok {
+		op = raw
+	}
+	if !f.ops[op] {
+		return false
+	}
+	if len(f.include) > 0 && !matchAny(f.include, root, e.Path) {
+		return false
+	}
+	return !matchAny(f.exclude, root, e.Path)
+}
+
+func matchAny(patterns []string, root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = path
+	}
+	for _, pattern := range patterns {
+		target := filepath.Base(path)
+		if strings.Contains(pattern, "/") {
+			target = rel
+		}
+		if ok, _ := filepath.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// printer writes events in one of the output formats.
+type printer struct {
+	w      io.Writer
+	format string
+	header bool
+}
+
+func newPrinter(w io.Writer, format string) (*printer, error) {
+	switch format {
+	case "text", "json", "tsv":
+		return &printer{w: w, format: format}, nil
+	default:
+		return nil, fmt.Errorf("no such output format %q, possible values: text, json, tsv", format)
+	}
+}
+
+func (p *printer) print(e event) error {
+	var err error
+	switch p.format {
+	case "json":
+		var line []byte
+		if line, err = json.Marshal(e); err == nil {
+			_, err = p.w.Write(append(line, '\n'))
+		}
+	case "tsv":
+		if !p.header {
+			p.header = true
+			if _, err = io.WriteString(p.w, "time\tevent\tpath\tsize\tis_dir\tfrom\n"); err != nil {
+				return err
+			}
+		}
+		_, err = fmt.Fprintf(p.w, "%s\t%s\t%s\t%d\t%t\t%s\n", e.Time.Format(time.RFC3339Nano), e.Op, tsvField(e.Path), e.Size, e.IsDir, tsvField(e.From))
+	default:
+		kind := "file"
+		if e.IsDir {
+			kind = "dir"
+		}
+		path := e.Path
+		if e.From != "" {
+			path = e.From + " -> " + e.Path
+		}
+		_, err = fmt.Fprintf(p.w, "%s  %-8s  %-4s  %10s  %s\n", e.Time.Format("2006-01-02 15:04:05"), e.Op, kind, strconv.FormatInt(e.Size, 10), path)
+	}
+	return err
+}
+
+// tsvField keeps a name with tabs or newlines on its line.
+func tsvField(s string) string {
+	return strings.NewReplacer("\\", `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
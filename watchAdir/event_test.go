@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestFilter(t *testing.T) {
+	flt, err := newFilter("create,write", []string{"*.go", "docs/*"}, []string{"*_test.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		op, path string
+		keep     bool
+	}{
+		{"create", "/src/main.go", true},
+		{"write", "/src/pkg/util.go", true},
+		{"write", "/src/docs/index.md", true},
+		{"write", "/src/pkg/docs/index.md", false},
+		{"create", "/src/main_test.go", false},
+		{"remove", "/src/main.go", false},
+		{"create", "/src/README.md", false},
+	} {
+		if got := flt.keep("/src", event{Op: tc.op, Path: tc.path}); got != tc.keep {
+			t.Errorf("%s %s: keep = %v", tc.op, tc.path, got)
+		}
+	}
+	for _, bad := range [][]string{{"create,mkdir"}, {"create", "[a-"}} {
+		include := []string(nil)
+		if len(bad) > 1 {
+			include = bad[1:]
+		}
+		if _, err := newFilter(bad[0], include, nil); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestPrinterFormats(t *testing.T) {
+	e := event{Time: time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC), Op: "write", Path: "/src/a\tb.txt", Size: 12}
+	var b bytes.Buffer
+	p, _ := newPrinter(&b, "tsv")
+	p.print(e)
+	p.print(e)
+	line := "2024-05-06T07:08:09Z\twrite\t/src/a\\tb.txt\t12\tfalse\t\n"
+	if b.String() != "time\tevent\tpath\tsize\tis_dir\tfrom\n"+line+line {
+		t.Fatalf("tsv %q", b.String())
+	}
+
+	b.Reset()
+	p, _ = newPrinter(&b, "json")
+	p.print(e)
+	var got event
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil || got != e {
+		t.Fatalf("json %q: %+v, %v", b.String(), got, err)
+	}
+
+	if _, err := newPrinter(&b, "xml"); err == nil {
+		t.Fatal("xml accepted")
+	}
+}
+
+// TestRenamedFrom breaks when fsnotify no longer shows the old name of a move, the coalescer would stop pairing renames.
+func TestRenamedFrom(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "old.txt"), filepath.Join(dir, "new.txt")
+	os.WriteFile(from, nil, 0o644)
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(from, to); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-w.Events:
+			if !e.Has(fsnotify.Create) {
+				continue
+			}
+			if got := renamedFrom(e); got != from {
+				t.Fatalf("renamedFrom(%s) = %q", e, got)
+			}
+			return
+		case err := <-w.Errors:
+			t.Fatal(err)
+		case <-timeout:
+			t.Fatal("no create for the move")
+		}
+	}
+}
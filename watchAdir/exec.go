@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runner runs a command template for the events it is given. Events of a path that keep coming within the debounce
+// window are one run, with batch every path changed within the window is one run.
+type runner struct {
+	command  string
+	debounce time.Duration
+	batch    bool
+	sem      chan struct{} // limits the commands running at once
+	stdout   io.Writer
+	stderr   io.Writer
+
+	mu     sync.Mutex
+	groups map[string]*runGroup
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// runGroup is what one run is waiting for.
+type runGroup struct {
+	events []event
+	timer  *time.Timer
+}
+
+func newRunner(command string, debounce time.Duration, batch bool, jobs int) *runner {
+	if jobs < 1 {
+		jobs = 1
+	}
+	return &runner{
+		command:  command,
+		debounce: debounce,
+		batch:    batch,
+		sem:      make(chan struct{}, jobs),
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
+		groups:   make(map[string]*runGroup),
+	}
+}
+
+func (r *runner) add(e event) {
+	key := e.Path
+	if r.batch {
+		key = ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	g := r.groups[key]
+	if g != nil && g.timer.Stop() {
+		g.timer.Reset(r.debounce)
+	} else {
+		// a group whose timer already fired is being run, the event starts a new one
+		g = &runGroup{}
+		r.groups[key] = g
+		r.wg.Add(1)
+		g.timer = time.AfterFunc(r.debounce, func() { r.fire(key, g) })
+	}
+	g.events = appendLatest(g.events, e)
+}
+
+// appendLatest keeps one event per path, the latest, in the order the paths first changed.
+func appendLatest(events []event, e event) []event {
+	for i := range events {
+		if events[i].Path == e.Path {
+			events[i] = e
+			return events
+		}
+	}
+	return append(events, e)
+}
+
+func (r *runner) fire(key string, g *runGroup) {
+	r.mu.Lock()
+	if r.groups[key] == g {
+		delete(r.groups, key)
+	}
+	events := g.events
+	r.mu.Unlock()
+	r.run(events)
+}
+
+func (r *runner) run(events []event) {
+	defer r.wg.Done()
+	r.sem <- struct{}{}
+	defer func() { <-r.sem }()
+	last := events[len(events)-1]
+	paths := make([]string, len(events))
+	for i, e := range events {
+		paths[i] = e.Path
+	}
+	script := expand(r.command, last, paths)
+	logrus.Debugf("running %s", script)
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Stdout, cmd.Stderr = r.stdout, r.stderr
+	cmd.Env = append(os.Environ(),
+		"WATCHADIR_EVENT="+last.Op,
+		"WATCHADIR_PATH="+last.Path,
+		"WATCHADIR_PATHS="+strings.Join(paths, "\n"),
+	)
+	if err := cmd.Run(); err != nil {
+		logrus.Warnf("%s: %s", script, err)
+	}
+}
+
+// close runs what is still waiting for its window to end and waits for every command.
+func (r *runner) close() {
+	r.mu.Lock()
+	r.closed = true
+	for key, g := range r.groups {
+		if g.timer.Stop() {
+			delete(r.groups, key)
+			go r.run(g.events)
+		}
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+// expand fills {path}, {name}, {dir} and {event} with the last event and {paths} with every path, quoted for sh.
+func expand(command string, last event, paths []string) string {
+	quoted := make([]string, len(paths))
+	for i, p := range paths {
+		quoted[i] = shellQuote(p)
+	}
+	return strings.NewReplacer(
+		"{paths}", strings.Join(quoted, " "),
+		"{path}", shellQuote(last.Path),
+		"{name}", shellQuote(filepath.Base(last.Path)),
+		"{dir}", shellQuote(filepath.Dir(last.Path)),
+		"{event}", last.Op,
+	).Replace(command)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
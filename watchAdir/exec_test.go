@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer collects the output of commands running at once.
+type lockedBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (l *lockedBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.Write(p)
+}
+
+func (l *lockedBuffer) lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lines := strings.Fields(l.b.String())
+	sort.Strings(lines)
+	return lines
+}
+
+func TestRunnerDebounces(t *testing.T) {
+	var out lockedBuffer
+	r := newRunner("echo {event}:{name}", 50*time.Millisecond, false, 2)
+	r.stdout = &out
+	for range 5 {
+		r.add(event{Op: "write", Path: "/data/a.txt"})
+		time.Sleep(10 * time.Millisecond)
+	}
+	r.add(event{Op: "create", Path: "/data/b.txt"})
+	time.Sleep(150 * time.Millisecond)
+	r.add(event{Op: "remove", Path: "/data/a.txt"})
+	r.close()
+	if got := strings.Join(out.lines(), " "); got != "create:b.txt remove:a.txt write:a.txt" {
+		t.Fatalf("ran %q", got)
+	}
+}
+
+func TestRunnerBatch(t *testing.T) {
+	var out lockedBuffer
+	r := newRunner("echo {paths}; echo \"$WATCHADIR_PATHS\" | wc -l", time.Hour, true, 1)
+	r.stdout = &out
+	r.add(event{Op: "write", Path: "/data/a b"})
+	r.add(event{Op: "create", Path: "/data/c"})
+	r.add(event{Op: "write", Path: "/data/a b"})
+	r.close() // runs the window still open
+	if got := strings.Join(out.lines(), " "); got != "/data/a /data/c 2 b" {
+		t.Fatalf("ran %q", got)
+	}
+}
+
+func TestRunnerConcurrencyLimit(t *testing.T) {
+	dir := t.TempDir()
+	r := newRunner(`mkdir `+filepath.Join(dir, "lock")+` || touch `+filepath.Join(dir, "overlap")+`; sleep 0.05; rmdir `+filepath.Join(dir, "lock"), time.Millisecond, false, 1)
+	for _, name := range []string{"a", "b", "c"} {
+		r.add(event{Op: "write", Path: name})
+	}
+	r.close()
+	if _, err := os.Stat(filepath.Join(dir, "overlap")); err == nil {
+		t.Fatal("two commands ran at once with --jobs 1")
+	}
+}
+
+func TestExpandQuotes(t *testing.T) {
+	got := expand("mv {path} {dir}/done-{name} # {event}", event{Op: "create", Path: "/in/it's.txt"}, []string{"/in/it's.txt"})
+	if got != `mv '/in/it'\''s.txt' '/in'/done-'it'\''s.txt' # create` {
+		t.Fatalf("got %s", got)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+var recordCmd = &cli.Command{
+	Name:      "record",
+	Usage:     "write every event to a journal for watchAdir report",
+	ArgsUsage: "[PATH...]",
+	Description: "the journal holds one JSON event per line, as --format json prints them. every kind of event is recorded unless --events is given,\n" +
+		"the other flags of watchAdir (--depth, --coalesce, --until-count, ...) apply.",
+	Action: record,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "journal",
+			Aliases:  []string{"o"},
+			Usage:    "write the events to `FILE`, events on the journal itself are left out",
+			Required: true,
+		},
+		&cli.BoolFlag{
+			Name:  "append",
+			Usage: "add to the journal instead of replacing it",
+		},
+	},
+}
+
+var reportCmd = &cli.Command{
+	Name:      "report",
+	Usage:     "summarize a journal: files created per directory, most modified files and a timeline",
+	ArgsUsage: "JOURNAL",
+	Action:    report,
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "top",
+			Usage: "list the `N` busiest directories and files",
+			Value: 10,
+		},
+		&cli.IntFlag{
+			Name:  "bars",
+			Usage: "split the timeline in about `N` bars",
+			Value: 20,
+		},
+	},
+}
+
+func record(ctx context.Context, cmd *cli.Command) error {
+	path, err := filepath.Abs(cmd.String("journal"))
+	if err != nil {
+		return err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if cmd.Bool("append") {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	events := "create,write,remove,rename,chmod"
+	if cmd.IsSet("events") {
+		events = cmd.String("events")
+	}
+	werr := watch(ctx, cmd, &flushingWriter{w}, "json", events, path)
+	if err := errors.Join(w.Flush(), f.Close()); err != nil {
+		return err
+	}
+	return werr
+}
+
+// flushingWriter hands every line to the file right away, a journal cut by a crash keeps what was seen.
+type flushingWriter struct {
+	w *bufio.Writer
+}
+
+func (f *flushingWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err == nil {
+		err = f.w.Flush()
+	}
+	return n, err
+}
+
+func readJournal(r io.Reader) ([]event, error) {
+	var events []event
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var e event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
+
+func report(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() != 1 {
+		return errors.New("give the journal to report on")
+	}
+	f, err := os.Open(cmd.Args().First())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	events, err := readJournal(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", cmd.Args().First(), err)
+	}
+	return writeReport(os.Stdout, events, int(cmd.Int("top")), int(cmd.Int("bars")))
+}
+
+// count is a name and how often it was seen.
+type count struct {
+	name string
+	n    int
+}
+
+func topCounts(counts map[string]int, top int) []count {
+	list := make([]count, 0, len(counts))
+	for name, n := range counts {
+		list = append(list, count{name, n})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].n != list[j].n {
+			return list[i].n > list[j].n
+		}
+		return list[i].name < list[j].name
+	})
+	if top > 0 && len(list) > top {
+		list = list[:top]
+	}
+	return list
+}
+
+// writeReport reads raw and coalesced events alike, created files count once per create and modifications once per write.
+func writeReport(w io.Writer, events []event, top, bars int) error {
+	if len(events) == 0 {
+		_, err := fmt.Fprintln(w, "the journal is empty")
+		return err
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	first, last := events[0].Time, events[len(events)-1].Time
+	kinds := make(map[string]int)
+	createdIn := make(map[string]int)
+	modifiedFiles := make(map[string]int)
+	for _, e := range events {
+		kinds[e.Op]++
+		switch e.Op {
+		case "create", created:
+			if !e.IsDir {
+				createdIn[filepath.Dir(e.Path)]++
+			}
+		case "write", modified:
+			modifiedFiles[e.Path]++
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%d events from %s to %s (%s)\n", len(events), first.Format("2006-01-02 15:04:05"), last.Format("2006-01-02 15:04:05"), last.Sub(first).Round(time.Second))
+	for _, c := range topCounts(kinds, 0) {
+		fmt.Fprintf(bw, "  %-8s %d\n", c.name, c.n)
+	}
+	fmt.Fprintln(bw, "\nfiles created per directory:")
+	for _, c := range topCounts(createdIn, top) {
+		fmt.Fprintf(bw, "%8d  %s\n", c.n, c.name)
+	}
+	fmt.Fprintln(bw, "\nmost modified files:")
+	for _, c := range topCounts(modifiedFiles, top) {
+		fmt.Fprintf(bw, "%8d  %s\n", c.n, c.name)
+	}
+	writeTimeline(bw, events, bars)
+	return bw.Flush()
+}
+
+var barSizes = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour,
+}
+
+// writeTimeline draws a histogram of the events, one bar per round interval.
+func writeTimeline(w io.Writer, events []event, bars int) {
+	if bars < 1 {
+		bars = 1
+	}
+	first, last := events[0].Time, events[len(events)-1].Time
+	size := barSizes[len(barSizes)-1]
+	for _, s := range barSizes {
+		if last.Sub(first)/s < time.Duration(bars) {
+			size = s
+			break
+		}
+	}
+	start := first.Truncate(size)
+	counts := make([]int, int(last.Sub(start)/size)+1)
+	most := 0
+	for _, e := range events {
+		i := int(e.Time.Sub(start) / size)
+		counts[i]++
+		most = max(most, counts[i])
+	}
+	layout := "15:04:05"
+	if last.Sub(first) >= 24*time.Hour {
+		layout = "01-02 15:04"
+	}
+	const width = 50
+	fmt.Fprintf(w, "\ntimeline (%s per bar):\n", size)
+	for i, n := range counts {
+		bar := strings.Repeat("#", (n*width+most-1)/most)
+		fmt.Fprintf(w, "  %s  %-*s %d\n", start.Add(time.Duration(i)*size).Format(layout), width, bar, n)
+	}
+}
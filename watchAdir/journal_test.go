@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournalReport(t *testing.T) {
+	start := time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC)
+	var journal bytes.Buffer
+	p, _ := newPrinter(&journal, "json")
+	for i, e := range []event{
+		{Op: "create", Path: "/run/out"},
+		{Op: "create", Path: "/run/out/a.bam"},
+		{Op: "create", Path: "/run/out/b.bam"},
+		{Op: "write", Path: "/run/log.txt"},
+		{Op: "write", Path: "/run/log.txt"},
+		{Op: created, Path: "/run/c.txt"},
+		{Op: modified, Path: "/run/out/a.bam"},
+		{Op: "remove", Path: "/run/out/b.bam"},
+	} {
+		e.Time = start.Add(time.Duration(i) * 10 * time.Minute)
+		e.IsDir = e.Path == "/run/out"
+		p.print(e)
+	}
+	journal.WriteString("\n")
+
+	events, err := readJournal(&journal)
+	if err != nil || len(events) != 8 {
+		t.Fatalf("read %d events, %v", len(events), err)
+	}
+	var b bytes.Buffer
+	if err := writeReport(&b, events, 1, 4); err != nil {
+		t.Fatal(err)
+	}
+	got := b.String()
+	for _, want := range []string{
+		"8 events from 2024-05-06 10:00:00 to 2024-05-06 11:10:00 (1h10m0s)",
+		"  create   3\n",
+		"files created per directory:\n       2  /run/out\n\n",
+		"most modified files:\n       2  /run/log.txt\n\n",
+		"timeline (30m0s per bar):\n  10:00:00  " + strings.Repeat("#", 50) + " 3\n  10:30:00  " + strings.Repeat("#", 50) + " 3\n  11:00:00  " + strings.Repeat("#", 34),
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("report has no %q:\n%s", want, got)
+		}
+	}
+
+	if _, err := readJournal(strings.NewReader("{\"event\": \"create\"}\nnot json\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("bad journal: %v", err)
+	}
+}
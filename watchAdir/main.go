@@ -3,29 +3,33 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
 	"github.com/pydpll/errorutils"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v3"
 )
 
 var (
-	Version       string
-	Revision      = ".0"
-	CommitId      string
-	requestedTime time.Duration
+	Version  string
+	Revision = ".0"
+	CommitId string
 )
 
 var app = cli.Command{
-	Name:    "watchAdir",
-	Usage:   "Notifies when a directory is changed with printouts",
-	Flags:   appFlags,
-	Version: fmt.Sprintf("%s%s (%s)", Version, Revision, CommitId),
-	Action:  vidi,
+	Name:      "watchAdir",
+	Usage:     "Notifies when a directory is changed with printouts",
+	ArgsUsage: "[PATH...] (default: the current directory)",
+	Description: "exits 0 when a stop condition (--until-count, --until-match) is met or when the timeout ends a watch without one,\n" +
+		"4 when the timeout ends before the condition is met and 130 when interrupted, printing a summary of the events seen.",
+	Flags:    appFlags,
+	Commands: []*cli.Command{recordCmd, reportCmd},
+	Version:  fmt.Sprintf("%s%s (%s)", Version, Revision, CommitId),
+	Action:   vidi,
 }
 
 func main() {
@@ -57,92 +61,103 @@ var appFlags []cli.Flag = []cli.Flag{
 		Usage:   "Sets levels of directory to watch. It can watch directories that alread exist, -1 traverses the whole dir structure. default: 0",
 		Value:   0,
 	},
+	&cli.StringSliceFlag{
+		Name:  "ignore",
+		Usage: "never watch nor report directories and files named `GLOB`, can be repeated",
+		Value: defaultIgnore,
+	},
+	&cli.StringFlag{
+		Name:    "format",
+		Aliases: []string{"f"},
+		Usage:   "output format of the events. possible values: text, json (one object per line), tsv (with a header)",
+		Value:   "text",
+	},
+	&cli.StringFlag{
+		Name:  "events",
+		Usage: "comma separated `EVENTS` to report. possible values: create, write, remove, rename, chmod",
+		Value: defaultEvents,
+	},
+	&cli.StringSliceFlag{
+		Name:    "include",
+		Aliases: []string{"i"},
+		Usage:   "only report paths matching `GLOB`, can be repeated. globs with a slash match the path relative to the watched directory, the others the name",
+	},
+	&cli.StringSliceFlag{
+		Name:    "exclude",
+		Aliases: []string{"e"},
+		Usage:   "do not report paths matching `GLOB`, can be repeated",
+	},
+	&cli.IntFlag{
+		Name:  "until-count",
+		Usage: "exit after reporting `N` events",
+	},
+	&cli.StringFlag{
+		Name:  "until-match",
+		Usage: "exit after reporting an event on a path matching `GLOB`, matched as --include",
+	},
+	&cli.DurationFlag{
+		Name:    "coalesce",
+		Aliases: []string{"c"},
+		Usage:   "merge the events of `WINDOW` in the net change of each path: created, modified, deleted, renamed (from -> to) or chmod. 0 reports every event",
+	},
+	&cli.StringFlag{
+		Name:    "exec",
+		Aliases: []string{"x"},
+		Usage:   "run `COMMAND` with sh instead of printing the events, e.g. 'gzip {path}'. {path}, {name}, {dir} and {event} are the changed path quoted and the event, {paths} every path of a --batch",
+	},
+	&cli.DurationFlag{
+		Name:  "debounce",
+		Usage: "run --exec once the path has been quiet for `DURATION`, an editor save or a growing file is one run",
+		Value: 200 * time.Millisecond,
+	},
+	&cli.BoolFlag{
+		Name:  "batch",
+		Usage: "run --exec once for every change within the debounce window, with all the paths in {paths}",
+	},
+	&cli.IntFlag{
+		Name:    "jobs",
+		Aliases: []string{"j"},
+		Usage:   "run at most `N` --exec commands at once",
+		Value:   1,
+	},
 }
 
 func vidi(ctx context.Context, cmd *cli.Command) error {
-	var err error
-	requestedTime, err = time.ParseDuration(cmd.String("timeout"))
-	errorutils.ExitOnFail(err, errorutils.WithMsg("unit suffixes should be SI units"))
-	if requestedTime <= 0 {
-		return errorutils.NewReport("Zero time is a no-op comand", "7aEUnYgPNQf")
-	}
-
-	currDir, err := os.Getwd()
-	errorutils.ExitOnFail(err, errorutils.WithMsg("failed to get current directory"))
-	watcher, err := fsnotify.NewWatcher()
-	errorutils.ExitOnFail(err)
-	defer watcher.Close()
-
-	paths, err := getPathsToWatch(currDir, int(cmd.Int("depth")))
-	errorutils.ExitOnFail(err)
-	for _, target := range paths {
-		err = watcher.Add(target)
-	}
-	if err != nil {
-		return err
-	}
-
-	go func() {
-		for {
-			// Watch for events.
-			for event := range watcher.Events {
-				switch event.Op {
-				case fsnotify.Create:
-					// check if it is a directory
-					if info, err := os.Stat(event.Name); info != nil && info.IsDir() {
-						fmt.Println("A new directory named", event.Name, "was created on", time.Now().Format("January 2, 2006 at 15:04:05"))
-						if d := cmd.Int("depth"); d > 0 || d == -1 {
-							newPaths, err := getPathsToWatch(event.Name, int(d)-1)
-							errorutils.WarnOnFail(err, errorutils.WithMsg("failed to get paths to watch for new directory"))
-							for _, target := range newPaths {
-								err = watcher.Add(target)
-								errorutils.WarnOnFail(err, errorutils.WithMsg("failed to watch new directory"))
-							}
-						}
-						continue
-					} else if err != nil {
-						logrus.Debug("failed to stat new file", err, " SKIPPING")
-						continue
-					}
-					fmt.Println("A new file named", event.Name, "was created on", time.Now().Format("January 2, 2006 at 15:04:05"))
-
-				case fsnotify.Write:
-					// A file was modified.
-					fmt.Println("The file", event.Name, "was modified on", time.Now().Format("January 2, 2006 at 15:04:05"))
-
-				case fsnotify.Remove:
-					// A file was removed.
-					fmt.Println("The file or directory ", event.Name, "was removed on", time.Now().Format("January 2, 2006 at 15:04:05"))
-				default:
-					continue
-				}
-			}
-		}
-	}()
-
-	time.Sleep(requestedTime)
-	return nil
+	return watch(ctx, cmd, os.Stdout, cmd.String("format"), cmd.String("events"), "")
 }
 
-func getPathsToWatch(path string, depth int) ([]string, error) {
-	var paths []string
-	if depth == 0 {
-		return []string{path}, nil
-	}
-	paths = append(paths, path) // includes the current path
-	entries, err := os.ReadDir(path)
-	logrus.Debugf("entries in %s: %#v", path, entries)
-	if err != nil {
-		return nil, err
+// watch runs watchDirs with the flags of cmd, writing events in format to w. SIGINT and SIGTERM end it with a summary.
+func watch(ctx context.Context, cmd *cli.Command, w io.Writer, format, events, skip string) error {
+	timeout, err := time.ParseDuration(cmd.String("timeout"))
+	errorutils.ExitOnFail(err, errorutils.WithMsg("unit suffixes should be SI units"))
+	if timeout <= 0 {
+		return errorutils.NewReport("Zero time is a no-op comand", "7aEUnYgPNQf")
 	}
-	for _, entry := range entries {
-		if entry.IsDir() {
-			subPaths, err := getPathsToWatch(filepath.Join(path, entry.Name()), depth-1)
-			if err != nil {
-				return nil, err
-			}
-			paths = append(paths, subPaths...)
-		}
+	roots := cmd.Args().Slice()
+	if len(roots) == 0 {
+		currDir, err := os.Getwd()
+		errorutils.ExitOnFail(err, errorutils.WithMsg("failed to get current directory"))
+		roots = []string{currDir}
 	}
-	return paths, nil
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return watchDirs(ctx, watchOptions{
+		Roots:      roots,
+		Depth:      int(cmd.Int("depth")),
+		Ignore:     cmd.StringSlice("ignore"),
+		Format:     format,
+		Events:     events,
+		Include:    cmd.StringSlice("include"),
+		Exclude:    cmd.StringSlice("exclude"),
+		Skip:       skip,
+		Coalesce:   cmd.Duration("coalesce"),
+		Exec:       cmd.String("exec"),
+		Debounce:   cmd.Duration("debounce"),
+		Batch:      cmd.Bool("batch"),
+		Jobs:       int(cmd.Int("jobs")),
+		UntilCount: int(cmd.Int("until-count")),
+		UntilMatch: cmd.String("until-match"),
+		Timeout:    timeout,
+		Summary:    os.Stderr,
+	}, w)
 }
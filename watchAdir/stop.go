@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tally counts the reported events and closes met when a stop condition holds.
+type tally struct {
+	untilCount int
+	untilMatch []string
+	roots      []string
+	met        chan struct{}
+
+	mu      sync.Mutex
+	started time.Time
+	counts  map[string]int
+	paths   map[string]bool
+	total   int
+	matched string
+	once    sync.Once
+}
+
+func newTally(roots []string, untilCount int, untilMatch string) *tally {
+	t := &tally{untilCount: untilCount, roots: roots, met: make(chan struct{}), started: time.Now(), counts: make(map[string]int), paths: make(map[string]bool)}
+	if untilMatch != "" {
+		t.untilMatch = []string{untilMatch}
+	}
+	return t
+}
+
+// waiting says whether the run ends on a condition rather than the walltime.
+func (t *tally) waiting() bool {
+	return t.untilCount > 0 || len(t.untilMatch) > 0
+}
+
+func (t *tally) add(e event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.total++
+	t.counts[e.Op]++
+	t.paths[e.Path] = true
+	if t.untilCount > 0 && t.total >= t.untilCount {
+		t.once.Do(func() { close(t.met) })
+	}
+	if len(t.untilMatch) > 0 && t.matched == "" && matchAny(t.untilMatch, rootOf(t.roots, e.Path), e.Path) {
+		t.matched = e.Path
+		t.once.Do(func() { close(t.met) })
+	}
+}
+
+// summary is printed when watchAdir is stopped or runs out of time.
+func (t *tally) summary(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(w, "watched %s for %s: %d events on %d paths\n", strings.Join(t.roots, ", "), time.Since(t.started).Round(time.Second), t.total, len(t.paths))
+	ops := make([]string, 0, len(t.counts))
+	for op := range t.counts {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		fmt.Fprintf(w, "  %-8s %d\n", op, t.counts[op])
+	}
+}
+
+// rootOf is the watched root holding path, the deepest when roots are nested.
+func rootOf(roots []string, path string) string {
+	best := ""
+	for _, root := range roots {
+		rel, err := filepath.Rel(root, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") && len(root) > len(best) {
+			best = root
+		}
+	}
+	if best == "" && len(roots) > 0 {
+		return roots[0]
+	}
+	return best
+}
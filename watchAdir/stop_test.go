@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func metYet(t *tally) bool {
+	select {
+	case <-t.met:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestTallyConditions(t *testing.T) {
+	count := newTally([]string{"/data"}, 2, "")
+	count.add(event{Op: "create", Path: "/data/a"})
+	if metYet(count) {
+		t.Fatal("met after one event")
+	}
+	count.add(event{Op: "write", Path: "/data/a"})
+	count.add(event{Op: "write", Path: "/data/a"}) // closing met twice would panic
+	if !metYet(count) {
+		t.Fatal("not met after two events")
+	}
+
+	match := newTally([]string{"/data", "/scratch"}, 0, "run*/DONE")
+	match.add(event{Op: "create", Path: "/data/DONE"})
+	match.add(event{Op: "create", Path: "/scratch/other/DONE"})
+	if metYet(match) {
+		t.Fatal("met by a path out of the glob")
+	}
+	match.add(event{Op: "create", Path: "/scratch/run7/DONE"})
+	if !metYet(match) || match.matched != "/scratch/run7/DONE" {
+		t.Fatalf("not met, matched %q", match.matched)
+	}
+
+	var b bytes.Buffer
+	match.summary(&b)
+	if !strings.Contains(b.String(), "3 events on 3 paths") || !strings.Contains(b.String(), "create   3") {
+		t.Fatalf("summary %q", b.String())
+	}
+}
+
+func TestRootOf(t *testing.T) {
+	roots := []string{"/data", "/data/run", "/scratch"}
+	for path, want := range map[string]string{
+		"/data/a":         "/data",
+		"/data/run/b":     "/data/run",
+		"/data/runner/c":  "/data",
+		"/scratch/x/y":    "/scratch",
+		"/elsewhere/file": "/data",
+	} {
+		if got := rootOf(roots, path); got != want {
+			t.Errorf("rootOf(%s) = %s, want %s", path, got, want)
+		}
+	}
+}
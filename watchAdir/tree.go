@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+var defaultIgnore = []string{".git", ".hg", ".svn", "node_modules", "__pycache__"}
+
+// tree keeps the watches in step with the directories under the roots: created directories are watched as deep as
+// --depth allows, removed or renamed ones are dropped with everything below them. Ignored names are never watched nor reported.
+type tree struct {
+	watcher *fsnotify.Watcher
+	depth   int
+	ignore  []string
+
+	mu   sync.Mutex
+	dirs map[string]int // watched directory → levels still watched below it, -1 is unlimited
+}
+
+func newTree(watcher *fsnotify.Watcher, depth int, ignore []string) (*tree, error) {
+	for _, pattern := range ignore {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("--ignore %q: %w", pattern, err)
+		}
+	}
+	return &tree{watcher: watcher, depth: depth, ignore: ignore, dirs: make(map[string]int)}, nil
+}
+
+// ignored says whether a component of path below its root matches an ignore pattern.
+func (t *tree) ignored(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return false
+	}
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		for _, pattern := range t.ignore {
+			if ok, _ := filepath.Match(pattern, part); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addRoot watches a file alone and a directory as deep as the tree goes.
+func (t *tree) addRoot(root string) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return t.add(root)
+	}
+	return t.addDir(root, t.depth, nil)
+}
+
+func (t *tree) add(path string) error {
+	if err := t.watcher.Add(path); err != nil {
+		if errors.Is(err, syscall.ENOSPC) {
+			return t.limitError(path)
+		}
+		return fmt.Errorf("watching %s: %w", path, err)
+	}
+	return nil
+}
+
+// limitError explains what to do when inotify refuses more watches, fsnotify only says the device is full.
+func (t *tree) limitError(path string) error {
+	t.mu.Lock()
+	watched := len(t.dirs)
+	t.mu.Unlock()
+	limit := "fs.inotify.max_user_watches"
+	if data, err := os.ReadFile("/proc/sys/fs/inotify/max_user_watches"); err == nil {
+		limit += " = " + strings.TrimSpace(string(data))
+	}
+	return fmt.Errorf("watching %s: the inotify watch limit (%s) is reached with %d directories watched by watchAdir and those of other programs. "+
+		"raise it with `sysctl fs.inotify.max_user_watches=524288` or watch less with --depth and --ignore", path, limit, watched)
+}
+
+// addDir watches dir and the directories below it within levels. found, when given, is told about every entry met,
+// they were created before the watch could see them.
+func (t *tree) addDir(dir string, levels int, found func(path string, isDir bool)) error {
+	if err := t.add(dir); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.dirs[dir] = levels
+	t.mu.Unlock()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil // gone already, its Remove event is on the way
+		}
+		return err
+	}
+	logrus.Debugf("entries in %s: %d", dir, len(entries))
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if t.matchesIgnore(entry.Name()) {
+			continue
+		}
+		if found != nil {
+			found(path, entry.IsDir())
+		}
+		if entry.IsDir() && levels != 0 {
+			if err := t.addDir(path, below(levels), found); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *tree) matchesIgnore(name string) bool {
+	for _, pattern := range t.ignore {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func below(levels int) int {
+	if levels < 0 {
+		return levels
+	}
+	return levels - 1
+}
+
+// update follows ev: a directory created where the depth allows is watched with what it already holds, reported through found,
+// and a directory removed or renamed away is forgotten with everything below it.
+func (t *tree) update(ev fsnotify.Event, found func(path string, isDir bool)) error {
+	if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+		t.remove(ev.Name)
+	}
+	if !ev.Has(fsnotify.Create) {
+		return nil
+	}
+	t.mu.Lock()
+	levels, watched := t.dirs[filepath.Dir(ev.Name)]
+	t.mu.Unlock()
+	if !watched || levels == 0 || t.matchesIgnore(filepath.Base(ev.Name)) {
+		return nil
+	}
+	if info, err := os.Lstat(ev.Name); err != nil || !info.IsDir() {
+		return nil
+	}
+	return t.addDir(ev.Name, below(levels), found)
+}
+
+// remove drops the watches of dir and its subdirectories, the kernel already dropped those of deleted ones.
+func (t *tree) remove(dir string) {
+	t.mu.Lock()
+	var gone []string
+	for path := range t.dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			gone = append(gone, path)
+			delete(t.dirs, path)
+		}
+	}
+	t.mu.Unlock()
+	for _, path := range gone {
+		t.watcher.Remove(path)
+	}
+}
+
+// isDir says whether path is a watched directory, for the events of paths that are gone.
+func (t *tree) isDir(path string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.dirs[path]
+	return ok
+}
+
+func (t *tree) list() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	dirs := make([]string, 0, len(t.dirs))
+	for dir := range t.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// foundEvents turns the entries met by addDir into create events.
+func foundEvents(handle func(event)) func(string, bool) {
+	return func(path string, isDir bool) {
+		e := event{Time: time.Now(), Op: "create", Path: path, IsDir: isDir}
+		if info, err := os.Lstat(path); err == nil {
+			e.Size = info.Size()
+		}
+		handle(e)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestTreeWatches(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"a/b/c", ".git/objects", "src/node_modules/x"} {
+		os.MkdirAll(filepath.Join(root, dir), 0o755)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	tr, err := newTree(watcher, 2, defaultIgnore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.addRoot(root); err != nil {
+		t.Fatal(err)
+	}
+	rel := func() []string {
+		var dirs []string
+		for _, dir := range tr.list() {
+			r, _ := filepath.Rel(root, dir)
+			dirs = append(dirs, r)
+		}
+		return dirs
+	}
+	if got := rel(); !slices.Equal(got, []string{".", "a", "a/b", "src"}) {
+		t.Fatalf("watched %v", got)
+	}
+
+	// a directory made below a/b is at the depth limit, one made in src is watched with what it holds
+	os.MkdirAll(filepath.Join(root, "a/b/new"), 0o755)
+	tr.update(fsnotify.Event{Name: filepath.Join(root, "a/b/new"), Op: fsnotify.Create}, nil)
+	os.MkdirAll(filepath.Join(root, "src/lib"), 0o755)
+	os.WriteFile(filepath.Join(root, "src/lib/x.go"), nil, 0o644)
+	var found []string
+	tr.update(fsnotify.Event{Name: filepath.Join(root, "src/lib"), Op: fsnotify.Create}, func(path string, isDir bool) {
+		found = append(found, filepath.Base(path))
+	})
+	if got := rel(); !slices.Equal(got, []string{".", "a", "a/b", "src", "src/lib"}) {
+		t.Fatalf("watched after creates %v", got)
+	}
+	if !slices.Equal(found, []string{"x.go"}) {
+		t.Fatalf("found %v", found)
+	}
+
+	os.Rename(filepath.Join(root, "a"), filepath.Join(root, "moved"))
+	tr.update(fsnotify.Event{Name: filepath.Join(root, "a"), Op: fsnotify.Rename}, nil)
+	if got := rel(); !slices.Equal(got, []string{".", "src", "src/lib"}) {
+		t.Fatalf("watched after rename %v", got)
+	}
+	if len(watcher.WatchList()) != 3 {
+		t.Fatalf("fsnotify still watches %v", watcher.WatchList())
+	}
+}
+
+func TestTreeIgnored(t *testing.T) {
+	tr, _ := newTree(nil, -1, []string{".git", "*.tmp"})
+	for path, want := range map[string]bool{
+		"/repo/.git":            true,
+		"/repo/.git/HEAD":       true,
+		"/repo/src/a.tmp":       true,
+		"/repo/src/main.go":     false,
+		"/repo":                 false,
+		"/repo/.github/ci.yaml": false,
+	} {
+		if got := tr.ignored("/repo", path); got != want {
+			t.Errorf("ignored(%s) = %v", path, got)
+		}
+	}
+	if _, err := newTree(nil, 0, []string{"[x"}); err == nil {
+		t.Fatal("bad glob accepted")
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pydpll/errorutils"
+)
+
+// watchOptions are the flags of a watch, see appFlags.
+type watchOptions struct {
+	Roots  []string
+	Depth  int
+	Ignore []string
+
+	Format           string
+	Events           string
+	Include, Exclude []string
+	Skip             string // never reported, the journal being recorded
+	Coalesce         time.Duration
+
+	Exec     string
+	Debounce time.Duration
+	Batch    bool
+	Jobs     int
+
+	UntilCount int
+	UntilMatch string
+	Timeout    time.Duration
+	Summary    io.Writer // told about the events seen unless a stop condition ended the watch
+
+	ready func() // called once every watch is in place
+}
+
+// watchDirs reports the changes under the roots to w until the timeout, a stop condition or the end of ctx.
+// With Exec the commands write to w instead.
+func watchDirs(ctx context.Context, opts watchOptions, w io.Writer) error {
+	flt, err := newFilter(opts.Events, opts.Include, opts.Exclude)
+	if err != nil {
+		return err
+	}
+	out, err := newPrinter(w, opts.Format)
+	if err != nil {
+		return err
+	}
+	if _, err := filepath.Match(opts.UntilMatch, ""); err != nil {
+		return fmt.Errorf("--until-match: %w", err)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	tr, err := newTree(watcher, opts.Depth, opts.Ignore)
+	if err != nil {
+		return err
+	}
+	roots := make([]string, len(opts.Roots))
+	for i, root := range opts.Roots {
+		if roots[i], err = filepath.Abs(root); err != nil {
+			return err
+		}
+		if err := tr.addRoot(roots[i]); err != nil {
+			return err
+		}
+	}
+
+	sink := func(e event) {
+		errorutils.WarnOnFail(out.print(e), errorutils.WithMsg("failed to print event"))
+	}
+	var r *runner
+	if opts.Exec != "" {
+		r = newRunner(opts.Exec, opts.Debounce, opts.Batch, opts.Jobs)
+		r.stdout = w
+		sink = r.add
+	}
+	t := newTally(roots, opts.UntilCount, opts.UntilMatch)
+	handle := func(e event) {
+		if e.Path != opts.Skip && flt.keep(rootOf(roots, e.Path), e) {
+			t.add(e)
+			sink(e)
+		}
+	}
+	var co *coalescer
+	if opts.Coalesce > 0 {
+		co = newCoalescer(opts.Coalesce, handle)
+		co.seed(tr.list())
+		handle = co.add
+	}
+	loopDone := make(chan struct{})
+	go func() {
+		watchLoop(tr, roots, handle)
+		close(loopDone)
+	}()
+	if opts.ready != nil {
+		opts.ready()
+	}
+
+	timer := time.NewTimer(opts.Timeout)
+	defer timer.Stop()
+	var end error
+	select {
+	case <-t.met:
+	case <-ctx.Done():
+		end = errorutils.NewReport("interrupted", "", errorutils.WithExitCode(130))
+	case <-timer.C:
+		if t.waiting() {
+			end = errorutils.NewReport(fmt.Sprintf("the stop condition was not met within %s", opts.Timeout), "", errorutils.WithExitCode(4))
+		}
+	}
+
+	// stop watching, then let the pending windows and commands finish before summing up
+	watcher.Close()
+	<-loopDone
+	if co != nil {
+		co.close()
+	}
+	if r != nil {
+		r.close()
+	}
+	select {
+	case <-t.met:
+	default:
+		if opts.Summary != nil {
+			t.summary(opts.Summary)
+		}
+	}
+	return end
+}
+
+// watchLoop hands every event out of the ignored paths to handle and keeps the watches of tr in step.
+func watchLoop(tr *tree, roots []string, handle func(event)) {
+	for {
+		select {
+		case ev, ok := <-tr.watcher.Events:
+			if !ok {
+				return
+			}
+			if tr.ignored(rootOf(roots, ev.Name), ev.Name) {
+				continue
+			}
+			gone := ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename)
+			for _, e := range newEvents(ev, time.Now()) {
+				if gone && tr.isDir(e.Path) {
+					e.IsDir = true
+				}
+				handle(e)
+			}
+			errorutils.WarnOnFail(tr.update(ev, foundEvents(handle)), errorutils.WithMsg("failed to watch new directory"))
+		case err, ok := <-tr.watcher.Errors:
+			if !ok {
+				return
+			}
+			errorutils.WarnOnFail(err, errorutils.WithMsg("watcher error"))
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// session is a watchDirs running on a temporary directory with its events in json.
+type session struct {
+	root   string
+	out    *lockedBuffer
+	cancel context.CancelFunc
+	done   chan struct{} // closed once watchDirs returned err
+	err    error
+}
+
+// startWatch watches a new temporary directory, or opts.Roots, after setup filled it.
+func startWatch(t *testing.T, opts watchOptions, setup func(root string)) *session {
+	t.Helper()
+	s := &session{root: t.TempDir(), out: &lockedBuffer{}, done: make(chan struct{})}
+	if opts.Roots == nil {
+		opts.Roots = []string{s.root}
+	}
+	if opts.Events == "" {
+		opts.Events = defaultEvents
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = time.Minute
+	}
+	opts.Format = "json"
+	ready := make(chan struct{})
+	opts.ready = func() { close(ready) }
+	if setup != nil {
+		setup(s.root)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go func() {
+		s.err = watchDirs(ctx, opts, s.out)
+		close(s.done)
+	}()
+	select {
+	case <-ready:
+	case <-s.done:
+		t.Fatal(s.err)
+	}
+	t.Cleanup(func() { s.stop() })
+	return s
+}
+
+func (s *session) path(rel string) string {
+	return filepath.Join(s.root, rel)
+}
+
+// events are the lines printed so far as "op path", paths relative to the root.
+func (s *session) events(t *testing.T) []string {
+	t.Helper()
+	s.out.mu.Lock()
+	data := s.out.b.String()
+	s.out.mu.Unlock()
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		var e event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("%q: %v", scanner.Text(), err)
+		}
+		rel, _ := filepath.Rel(s.root, e.Path)
+		line := e.Op + " " + rel
+		if e.From != "" {
+			from, _ := filepath.Rel(s.root, e.From)
+			line += " from " + from
+		}
+		if e.IsDir {
+			line += "/"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// waitFor waits until every wanted line was printed and returns all of them.
+func (s *session) waitFor(t *testing.T, want ...string) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := s.events(t)
+		missing := false
+		for _, w := range want {
+			if !slices.Contains(got, w) {
+				missing = true
+			}
+		}
+		if !missing {
+			return got
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("waited for %q, got %q", want, got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (s *session) stop() error {
+	s.cancel()
+	return s.wait()
+}
+
+func (s *session) wait() error {
+	select {
+	case <-s.done:
+		return s.err
+	case <-time.After(5 * time.Second):
+		return errors.New("watchDirs did not stop")
+	}
+}
+
+func write(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchFileOperations(t *testing.T) {
+	s := startWatch(t, watchOptions{}, nil)
+	write(t, s.path("a.txt"), "one")
+	s.waitFor(t, "write a.txt")
+	f, _ := os.OpenFile(s.path("a.txt"), os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString("two")
+	f.Close()
+	s.waitFor(t, "create a.txt", "write a.txt", "write a.txt")
+	os.Rename(s.path("a.txt"), s.path("b.txt"))
+	os.Remove(s.path("b.txt"))
+	// the directory is looked at when its event comes, it must still be there
+	os.Mkdir(s.path("dir"), 0o755)
+	s.waitFor(t, "create dir/")
+	os.Remove(s.path("dir"))
+
+	got := s.waitFor(t, "remove dir")
+	want := []string{"create a.txt", "write a.txt", "write a.txt", "rename a.txt", "create b.txt", "remove b.txt", "create dir/", "remove dir"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestWatchEventFilters(t *testing.T) {
+	s := startWatch(t, watchOptions{Events: "create,remove", Exclude: []string{"*.log"}}, nil)
+	write(t, s.path("run.log"), "x")
+	write(t, s.path("out.txt"), "x")
+	os.Remove(s.path("out.txt"))
+	got := s.waitFor(t, "remove out.txt")
+	if !slices.Equal(got, []string{"create out.txt", "remove out.txt"}) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestWatchDepthLimited(t *testing.T) {
+	s := startWatch(t, watchOptions{Depth: 1}, func(root string) {
+		os.MkdirAll(filepath.Join(root, "sub/deeper"), 0o755)
+	})
+	write(t, s.path("sub/deeper/hidden"), "x")
+	write(t, s.path("sub/seen"), "x")
+	os.Mkdir(s.path("sub/new"), 0o755)
+	write(t, s.path("sub/new/hidden"), "x")
+	write(t, s.path("marker"), "x")
+	got := s.waitFor(t, "create sub/seen", "create sub/new/", "create marker")
+	for _, line := range got {
+		if strings.Contains(line, "hidden") {
+			t.Fatalf("event below the depth: %q", got)
+		}
+	}
+}
+
+func TestWatchNewSubdirectories(t *testing.T) {
+	s := startWatch(t, watchOptions{Depth: -1}, nil)
+	// made faster than the watch of new can be added, the scan of new reports them
+	os.MkdirAll(s.path("new/inner"), 0o755)
+	write(t, s.path("new/inner/early"), "x")
+	s.waitFor(t, "create new/", "create new/inner/", "create new/inner/early")
+	write(t, s.path("new/inner/late"), "x")
+	s.waitFor(t, "create new/inner/late")
+
+	// a depth limit counts from the root, not from the new directory
+	d := startWatch(t, watchOptions{Depth: 2}, nil)
+	os.MkdirAll(d.path("a/b/c"), 0o755)
+	d.waitFor(t, "create a/", "create a/b/")
+	write(t, d.path("a/b/c/hidden"), "x")
+	write(t, d.path("a/b/seen"), "x")
+	got := d.waitFor(t, "create a/b/seen")
+	for _, line := range got {
+		if strings.Contains(line, "hidden") {
+			t.Fatalf("event below the depth: %q", got)
+		}
+	}
+}
+
+func TestWatchRenamedDirectory(t *testing.T) {
+	s := startWatch(t, watchOptions{Depth: -1}, func(root string) {
+		os.MkdirAll(filepath.Join(root, "old/inner"), 0o755)
+	})
+	os.Rename(s.path("old"), s.path("new"))
+	s.waitFor(t, "rename old/", "create new/", "create new/inner/")
+	write(t, s.path("new/inner/file"), "x")
+	got := s.waitFor(t, "create new/inner/file")
+	for _, line := range got {
+		if strings.Contains(line, "old/inner/file") {
+			t.Fatalf("event reported under the old name: %q", got)
+		}
+	}
+}
+
+func TestWatchIgnore(t *testing.T) {
+	s := startWatch(t, watchOptions{Depth: -1, Ignore: defaultIgnore}, func(root string) {
+		os.MkdirAll(filepath.Join(root, ".git/objects"), 0o755)
+	})
+	write(t, s.path(".git/index"), "x")
+	os.MkdirAll(s.path("node_modules/pkg"), 0o755)
+	write(t, s.path("node_modules/pkg/index.js"), "x")
+	write(t, s.path("main.go"), "x")
+	got := s.waitFor(t, "create main.go", "write main.go")
+	if !slices.Equal(got, []string{"create main.go", "write main.go"}) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestWatchMultipleRoots(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	s := startWatch(t, watchOptions{Roots: []string{first, second}, Events: "create"}, nil)
+	write(t, filepath.Join(first, "x"), "")
+	write(t, filepath.Join(second, "y"), "")
+	rel := func(path string) string {
+		r, _ := filepath.Rel(s.root, path)
+		return "create " + r
+	}
+	got := s.waitFor(t, rel(filepath.Join(first, "x")), rel(filepath.Join(second, "y")))
+	if len(got) != 2 {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestWatchCoalesced(t *testing.T) {
+	s := startWatch(t, watchOptions{Coalesce: 100 * time.Millisecond}, func(root string) {
+		os.WriteFile(filepath.Join(root, "doc.txt"), []byte("v1"), 0o644)
+		os.WriteFile(filepath.Join(root, "old.txt"), []byte("x"), 0o644)
+	})
+	// an editor save through a temporary file, a move and a file that does not last
+	write(t, s.path(".doc.txt.swp"), "v2")
+	os.Rename(s.path(".doc.txt.swp"), s.path("doc.txt"))
+	os.Rename(s.path("old.txt"), s.path("new.txt"))
+	write(t, s.path("tmp"), "")
+	os.Remove(s.path("tmp"))
+	got := s.waitFor(t, "modified doc.txt", "renamed new.txt from old.txt")
+	if len(got) != 2 {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestWatchExec(t *testing.T) {
+	s := startWatch(t, watchOptions{Exec: `printf '{"event": "ran", "path": "%s"}\n' "$WATCHADIR_PATH"`, Debounce: 20 * time.Millisecond}, nil)
+	for i := range 3 {
+		write(t, s.path("grows"), fmt.Sprint(i))
+	}
+	s.waitFor(t, "ran grows")
+	time.Sleep(100 * time.Millisecond)
+	if got := s.events(t); len(got) != 1 {
+		t.Fatalf("bursty writes ran %q", got)
+	}
+}
+
+func TestWatchStopConditions(t *testing.T) {
+	s := startWatch(t, watchOptions{UntilMatch: "*.done"}, nil)
+	write(t, s.path("step1"), "")
+	write(t, s.path("all.done"), "")
+	if err := s.wait(); err != nil {
+		t.Fatalf("--until-match: %v", err)
+	}
+
+	s = startWatch(t, watchOptions{UntilCount: 3, Timeout: 200 * time.Millisecond}, nil)
+	write(t, s.path("one"), "") // create and write, one short
+	var exit cli.ExitCoder
+	if err := s.wait(); !errors.As(err, &exit) || exit.ExitCode() != 4 {
+		t.Fatalf("timeout before the condition: %v", err)
+	}
+
+	var summary lockedBuffer
+	s = startWatch(t, watchOptions{Summary: &summary}, nil)
+	write(t, s.path("x"), "x")
+	s.waitFor(t, "write x")
+	if err := s.stop(); !errors.As(err, &exit) || exit.ExitCode() != 130 {
+		t.Fatalf("interrupt: %v", err)
+	}
+	if got := summary.b.String(); !strings.Contains(got, "2 events on 1 paths") {
+		t.Fatalf("summary %q", got)
+	}
+}